@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/inkyblackness/imgui-go/v4"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/romdb"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/session"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/settings"
+)
+
+// ROM_EXTENSIONS are the files the ROM browser lists unless it is asked
+// for all files.
+var ROM_EXTENSIONS = []string{".ch8", ".c8", ".sc8", ".xo8", ".eti", ".c8x", ".mc8", ".8o", ".gif"}
+
+// MAX_TITLE_SIZE is the largest file read to look up its title.
+const MAX_TITLE_SIZE = 16 << 20
+
+// TITLE_QUEUE is how many files can wait for their titles to be looked up.
+const TITLE_QUEUE = 256
+
+const (
+	FILTER_ROMS  = ""
+	FILTER_FILES = "*"
+)
+
+// browserFile is a file or directory listed in the ROM browser.
+type browserFile struct {
+	name string
+	path string
+	dir  bool
+	// size is -1 when the file is missing
+	size int64
+}
+
+// romBrowser picks ROMs from the directories, the recent files, the
+// favourites, and the files dropped on the window together.
+type romBrowser struct {
+	open bool
+	dir  string
+	list []browserFile
+	err  error
+	// filter is one of ROM_EXTENSIONS, FILTER_ROMS or FILTER_FILES
+	filter   string
+	selected string
+
+	dropped     []browserFile
+	showDropped bool
+
+	db *romdb.Database
+	// described caches the files shown outside dir by path
+	described map[string]browserFile
+	// titles caches the titles from db by path. A goroutine reads and
+	// hashes the files queued in lookups, so that the window doesn't wait
+	// for them.
+	titlesMu sync.Mutex
+	titles   map[string]string
+	lookups  chan string
+}
+
+func newROMBrowser(db *romdb.Database) *romBrowser {
+	b := &romBrowser{
+		db:        db,
+		described: map[string]browserFile{},
+		titles:    map[string]string{},
+		lookups:   make(chan string, TITLE_QUEUE),
+	}
+	go b.lookUpTitles()
+
+	return b
+}
+
+// browse lists dir.
+func (b *romBrowser) browse(dir string) {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	b.dir, b.list, b.err = dir, nil, nil
+	b.described = map[string]browserFile{}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		b.err = err
+		return
+	}
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".") || (!info.IsDir() && !b.matches(info.Name())) {
+			continue
+		}
+		b.list = append(b.list, b.describe(filepath.Join(dir, info.Name()), info))
+	}
+
+	// directories first
+	sort.SliceStable(b.list, func(i, j int) bool {
+		return b.list[i].dir && !b.list[j].dir
+	})
+}
+
+func (b *romBrowser) matches(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	switch b.filter {
+	case FILTER_FILES:
+		return true
+	case FILTER_ROMS:
+		for _, romExt := range ROM_EXTENSIONS {
+			if ext == romExt {
+				return true
+			}
+		}
+		return false
+	default:
+		return ext == b.filter
+	}
+}
+
+func (b *romBrowser) describe(path string, info os.FileInfo) browserFile {
+	return browserFile{
+		name: info.Name(),
+		path: path,
+		dir:  info.IsDir(),
+		size: info.Size(),
+	}
+}
+
+// title returns the title of file in the database. It is "" until the file
+// has been looked up.
+func (b *romBrowser) title(file browserFile) string {
+	if file.dir || file.size < 0 || file.size > MAX_TITLE_SIZE || b.db.Len() == 0 {
+		return ""
+	}
+
+	b.titlesMu.Lock()
+	defer b.titlesMu.Unlock()
+
+	if title, ok := b.titles[file.path]; ok {
+		return title
+	}
+	select {
+	case b.lookups <- file.path:
+		b.titles[file.path] = ""
+	default:
+		// the queue is full, the file is queued again on the next frame
+	}
+
+	return ""
+}
+
+func (b *romBrowser) lookUpTitles() {
+	for path := range b.lookups {
+		var title string
+		if program, err := ioutil.ReadFile(path); err == nil {
+			title = b.db.Title(settings.ROMKey(program))
+		}
+
+		b.titlesMu.Lock()
+		b.titles[path] = title
+		b.titlesMu.Unlock()
+	}
+}
+
+// describePath describes a file outside the listed directory, once.
+func (b *romBrowser) describePath(path string) browserFile {
+	if file, ok := b.described[path]; ok {
+		return file
+	}
+
+	file := browserFile{name: filepath.Base(path), path: path, size: -1}
+	if info, err := os.Stat(path); err == nil {
+		file = b.describe(path, info)
+	}
+	b.described[path] = file
+
+	return file
+}
+
+// drop lists files dropped on the window together.
+func (b *romBrowser) drop(names []string) {
+	b.dropped = nil
+	for _, name := range names {
+		b.dropped = append(b.dropped, b.describePath(name))
+	}
+	b.open = true
+	b.showDropped = true
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size < 0:
+		return "missing"
+	case size < 1024:
+		return fmt.Sprintf("%d B", size)
+	default:
+		return fmt.Sprintf("%.1f KB", float64(size)/1024)
+	}
+}
+
+// drawROMBrowser draws the ROM browser window. Double-clicking a ROM loads
+// it into the focused instance.
+func drawROMBrowser(app *instances) {
+	b := app.browser
+	if !b.open {
+		return
+	}
+	s := app.focused()
+	if b.dir == "" {
+		b.browse(s.Settings.BrowseDir)
+	}
+
+	imgui.SetNextWindowPosV(imgui.Vec2{X: 120, Y: 80}, imgui.ConditionAppearing, imgui.Vec2{})
+	imgui.SetNextWindowSizeV(imgui.Vec2{X: 560, Y: 420}, imgui.ConditionFirstUseEver)
+	if imgui.BeginV("ROM browser", &b.open, imgui.WindowFlagsNoCollapse) {
+		if imgui.BeginTabBar("Sources") {
+			if imgui.BeginTabItem("Files") {
+				drawBrowserDirectory(s, b)
+				imgui.EndTabItem()
+			}
+			if imgui.BeginTabItem("Recent") {
+				drawBrowserList(s, b, "recent", b.describeAll(s.Settings.Recent))
+				imgui.EndTabItem()
+			}
+			if imgui.BeginTabItem("Favourites") {
+				drawBrowserList(s, b, "favorites", b.describeAll(s.Settings.Favorites))
+				imgui.EndTabItem()
+			}
+			if len(b.dropped) > 0 {
+				var flags imgui.TabItemFlags
+				if b.showDropped {
+					flags = imgui.TabItemFlagsSetSelected
+					b.showDropped = false
+				}
+				if imgui.BeginTabItemV("Dropped", nil, flags) {
+					drawBrowserList(s, b, "dropped", b.dropped)
+					imgui.EndTabItem()
+				}
+			}
+			imgui.EndTabBar()
+		}
+
+		imgui.Separator()
+		if b.selected == "" {
+			imgui.Text("Double-click a ROM to load it.")
+		} else {
+			if imgui.Button("LOAD") {
+				resetWhenOnDrop(s, b.selected)
+			}
+			imgui.SameLine()
+			label := "ADD TO FAVOURITES"
+			if s.Settings.IsFavorite(b.selected) {
+				label = "REMOVE FROM FAVOURITES"
+			}
+			if imgui.Button(label) {
+				s.Settings.ToggleFavorite(b.selected)
+				s.SaveSettings()
+			}
+			imgui.SameLine()
+			imgui.Text(filepath.Base(b.selected))
+		}
+	}
+	imgui.End()
+}
+
+func (b *romBrowser) describeAll(paths []string) []browserFile {
+	files := make([]browserFile, len(paths))
+	for i, path := range paths {
+		files[i] = b.describePath(path)
+	}
+
+	return files
+}
+
+func drawBrowserDirectory(s *session.Session, b *romBrowser) {
+	if imgui.Button("UP") {
+		b.browse(filepath.Dir(b.dir))
+		saveBrowseDir(s, b)
+	}
+	imgui.SameLine()
+	if imgui.Button("HOME") {
+		if home, err := os.UserHomeDir(); err == nil {
+			b.browse(home)
+			saveBrowseDir(s, b)
+		}
+	}
+	imgui.SameLine()
+	imgui.PushItemWidth(120)
+	filterName := map[string]string{FILTER_ROMS: "ROMs", FILTER_FILES: "All files"}
+	label := func(filter string) string {
+		if name, ok := filterName[filter]; ok {
+			return name
+		}
+		return filter
+	}
+	if imgui.BeginCombo("##filter", label(b.filter)) {
+		for _, filter := range append(append([]string{FILTER_ROMS}, ROM_EXTENSIONS...), FILTER_FILES) {
+			if imgui.SelectableV(label(filter), filter == b.filter, 0, imgui.Vec2{}) {
+				b.filter = filter
+				b.browse(b.dir)
+			}
+		}
+		imgui.EndCombo()
+	}
+	imgui.PopItemWidth()
+	imgui.SameLine()
+	imgui.Text(b.dir)
+
+	if b.err != nil {
+		imgui.Text(fmt.Sprintf("Can't list the directory. (%s)", b.err))
+		return
+	}
+	drawBrowserList(s, b, "files", b.list)
+}
+
+// drawBrowserList draws files in a table. Clicking a directory opens it,
+// clicking a file selects it and double-clicking loads it.
+func drawBrowserList(s *session.Session, b *romBrowser, id string, files []browserFile) {
+	if len(files) == 0 {
+		imgui.Text("No files.")
+		return
+	}
+
+	flags := imgui.TableFlagsScrollY | imgui.TableFlagsRowBg | imgui.TableFlagsBordersInnerV | imgui.TableFlagsResizable
+	if !imgui.BeginTableV(id, 3, flags, imgui.Vec2{X: 0, Y: -imgui.FrameHeightWithSpacing()}, 0) {
+		return
+	}
+	imgui.TableSetupScrollFreeze(0, 1)
+	imgui.TableSetupColumnV("Name", imgui.TableColumnFlagsWidthStretch, 0, 0)
+	imgui.TableSetupColumnV("Size", imgui.TableColumnFlagsWidthFixed, 70, 0)
+	imgui.TableSetupColumnV("Title", imgui.TableColumnFlagsWidthStretch, 0, 0)
+	imgui.TableHeadersRow()
+
+	for _, file := range files {
+		imgui.TableNextRow()
+		imgui.TableNextColumn()
+
+		name := file.name
+		if file.dir {
+			name += "/"
+		}
+		if s.Settings.IsFavorite(file.path) {
+			name = "* " + name
+		}
+		selectableFlags := imgui.SelectableFlagsSpanAllColumns | imgui.SelectableFlagsAllowDoubleClick
+		if imgui.SelectableV(name+"##"+file.path, file.path == b.selected, selectableFlags, imgui.Vec2{}) {
+			switch {
+			case file.dir:
+				b.browse(file.path)
+				saveBrowseDir(s, b)
+			case imgui.IsMouseDoubleClicked(0):
+				resetWhenOnDrop(s, file.path)
+			default:
+				b.selected = file.path
+			}
+		}
+
+		imgui.TableNextColumn()
+		if !file.dir {
+			imgui.Text(formatSize(file.size))
+		}
+		imgui.TableNextColumn()
+		imgui.Text(b.title(file))
+	}
+	imgui.EndTable()
+}
+
+func saveBrowseDir(s *session.Session, b *romBrowser) {
+	if b.err == nil && s.Settings.BrowseDir != b.dir {
+		s.Settings.BrowseDir = b.dir
+		s.SaveSettings()
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/audio"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/chip8"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/emulator"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/recorder"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/session"
+)
+
+// runHeadless runs a ROM, or the VM of a save state, without a window for
+// the given number of 60 Hz frames, as fast as it can, and saves the
+// recording if one was requested. The inputs are given at their frames.
+// With a wavPath, the sound of every frame is rendered to that WAV file.
+func runHeadless(s *session.Session, path, statePath, wavPath string, frames int, inputs []emulator.Input) (err error) {
+	var vm *chip8.VirtualMachine
+	if statePath != "" {
+		vm, err = chip8.LoadStateFile(statePath)
+	} else {
+		vm, err = chip8.LoadFromFile(path, s.LoadConfig)
+	}
+	if err != nil {
+		return err
+	}
+
+	var wav *audio.WAVBackend
+	if wavPath != "" {
+		if wav, err = audio.NewWAVBackend(wavPath, false); err != nil {
+			return err
+		}
+		s.Audio = audio.NewAudio(wav)
+	} else {
+		s.Audio = audio.NewAudio(audio.NewNullBackend())
+	}
+	if err := s.Audio.Start(); err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := s.Audio.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	s.LoadVM(vm)
+	s.Log.Infof("rom", "Loading ROM completed. (PLATFORM: %s, BASE: %03X)", vm.Platform, vm.Base)
+
+	if s.RecordPath != "" {
+		s.StartRecording()
+	}
+	defer func() {
+		// the VM panics on invalid instructions; still save what led there
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+		if s.RecordPath != "" {
+			s.StopRecording()
+		}
+	}()
+
+	clock := s.Settings.ClockFor(s.ROMKey())
+	var picture *image.RGBA
+	for frame := uint64(0); frame < uint64(frames); frame++ {
+		for len(inputs) > 0 && inputs[0].Frame <= frame {
+			inputs[0].Apply(vm)
+			inputs = inputs[1:]
+		}
+		if err := emulator.RunFrame(vm, frame, clock); err != nil {
+			return err
+		}
+		emulator.Sound(vm, s.Audio)
+		if wav != nil {
+			if err := wav.WriteFrame(); err != nil {
+				return err
+			}
+		}
+
+		picture = recorder.Capture(picture, vm, s.Palette())
+		s.Recorder.AddFrame(picture)
+	}
+
+	return nil
+}
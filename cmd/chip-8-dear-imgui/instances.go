@@ -0,0 +1,262 @@
+package main
+
+import (
+	"image"
+
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/audio"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/chip8"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/emulator"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/gui"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/gui/framework_for_imgui"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/session"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/settings"
+)
+
+// INSTANCE_WIDTH is the width of the column of every instance after the
+// first, which are drawn right of the main layout.
+const INSTANCE_WIDTH = 320
+
+// instance is one emulator session with the texture of its display.
+type instance struct {
+	session *session.Session
+	texture framework_for_imgui.ImageTexture
+	// uploaded is the DisplayDrawn of the display in texture
+	uploaded uint64
+
+	// diff tells how the instance differed from the first one the last
+	// time both were at the same frame
+	diff string
+}
+
+// instances are the emulators shown side by side. Only the one with the
+// input focus plays its sound and takes the keyboard, unless the input is
+// mirrored to all.
+type instances struct {
+	window *gui.MasterWindow
+	// minSize is the smallest size of the main layout
+	minSize    settings.Window
+	fullscreen bool
+	list       []*instance
+	focus      int
+	mirror     bool
+	// lockstep runs all instances frame by frame while it is set
+	lockstep *emulator.Lockstep
+	// held are the keys of both keypads held down on the keyboard
+	held [2][16]bool
+	// turbo is set while the turbo key is held
+	turbo bool
+
+	browser *romBrowser
+}
+
+func newInstances(window *gui.MasterWindow, minSize settings.Window, primary *session.Session) *instances {
+	return &instances{
+		window:  window,
+		minSize: minSize,
+		list:    []*instance{{session: primary}},
+	}
+}
+
+// focused returns the session with the input focus.
+func (app *instances) focused() *session.Session {
+	return app.list[app.focus].session
+}
+
+func (app *instances) setFocus(index int) {
+	if index == app.focus || index >= len(app.list) {
+		return
+	}
+
+	app.releaseKeys()
+
+	// the sound follows the focus
+	from, to := app.list[app.focus].session, app.list[index].session
+	from.Audio, to.Audio = to.Audio, from.Audio
+	from.Emulator.SetAudio(from.Audio)
+	to.Emulator.SetAudio(to.Audio)
+
+	app.focus = index
+}
+
+// open starts another instance running the ROM of the focused one.
+func (app *instances) open() {
+	source := app.focused()
+	snapshot := source.Emulator.Snapshot()
+
+	vm, err := chip8.LoadROM(snapshot.Program, snapshot.Config)
+	if err != nil {
+		source.Log.Errorf("instances", "Opening instance failed. (%s)", err)
+		return
+	}
+
+	lockstep := app.lockstep != nil
+	app.setLockstep(false)
+
+	// only the focused instance is heard
+	sound, _ := audio.Open("null", "")
+	e := emulator.New(vm, sound)
+	s := source.Fork(e, sound)
+	e.OnFrame = s.HandleFrame
+	e.OnError = s.HandleError
+	s.LoadVM(vm)
+	e.SetSpeed(snapshot.Speed)
+	e.Start()
+
+	app.list = append(app.list, &instance{session: s})
+	app.resize(1)
+	s.Log.Infof("instances", "Instance #%d opened.", len(app.list))
+
+	app.setLockstep(lockstep)
+}
+
+// close quits an instance other than the first.
+func (app *instances) close(index int) {
+	if index == 0 || index >= len(app.list) {
+		return
+	}
+	app.setFocus(0)
+
+	lockstep := app.lockstep != nil
+	app.setLockstep(false)
+
+	closed := app.list[index]
+	app.window.Renderer.ReleaseImageTexture(&closed.texture)
+	closed.session.Emulator.Quit()
+	closed.session.Audio.Close()
+	if closed.session.Recorder.Recording() {
+		closed.session.StopRecording()
+	}
+
+	app.list = append(app.list[:index], app.list[index+1:]...)
+	app.resize(-1)
+	closed.session.Log.Infof("instances", "Instance #%d closed.", index+1)
+
+	app.setLockstep(lockstep)
+}
+
+// setLockstep starts or stops running the instances in lockstep. Starting
+// resets all of them, so that they run from the same frame.
+func (app *instances) setLockstep(on bool) {
+	if on == (app.lockstep != nil) {
+		return
+	}
+
+	if !on {
+		app.lockstep.Stop()
+		app.lockstep = nil
+		return
+	}
+
+	emulators := make([]*emulator.Emulator, len(app.list))
+	for i, instance := range app.list {
+		instance.session.Emulator.Reset()
+		instance.diff = ""
+		emulators[i] = instance.session.Emulator
+	}
+	app.lockstep = emulator.NewLockstep(emulators)
+	app.lockstep.Start()
+}
+
+// input gives a key change to the focused instance, or to all of them
+// when the input is mirrored.
+func (app *instances) input(in emulator.Input) {
+	switch {
+	case !app.mirror:
+		app.focused().Emulator.Input(in)
+	case app.lockstep != nil:
+		app.lockstep.Input(in)
+	default:
+		for _, instance := range app.list {
+			instance.session.Emulator.Input(in)
+		}
+	}
+}
+
+// releaseKeys releases the keys held down, so that none stays pressed
+// when the focus moves.
+func (app *instances) releaseKeys() {
+	if app.turbo {
+		app.focused().Emulator.SetTurbo(false)
+		app.turbo = false
+	}
+
+	for keypad := range app.held {
+		for pad, held := range app.held[keypad] {
+			if held {
+				app.input(emulator.Input{Keypad: keypad + 1, Key: uint(pad)})
+			}
+		}
+	}
+	app.held = [2][16]bool{}
+}
+
+// update uploads the displays the emulators have drawn since the last
+// call and compares the instances with the first one.
+func (app *instances) update() {
+	first := app.list[0].session.Emulator.Snapshot()
+
+	for i, instance := range app.list {
+		if drawn := instance.session.DisplayDrawn(); drawn != instance.uploaded {
+			instance.session.WithDisplay(func(display *image.RGBA) {
+				app.window.Renderer.UpdateImageTexture(&instance.texture, display)
+			})
+			instance.uploaded = drawn
+		}
+
+		if i == 0 {
+			continue
+		}
+		snapshot := instance.session.Emulator.Snapshot()
+		if snapshot.Frames != first.Frames {
+			continue
+		}
+		switch video, registers := snapshot.Diff(first); {
+		case video && registers:
+			instance.diff = "display & registers differ"
+		case video:
+			instance.diff = "display differs"
+		case registers:
+			instance.diff = "registers differ"
+		default:
+			instance.diff = "same as #1"
+		}
+	}
+}
+
+// resize makes room for columns more instances, or less when negative.
+func (app *instances) resize(columns int) {
+	if app.fullscreen {
+		return
+	}
+
+	size := app.window.Platform.DisplaySize()
+	app.window.Platform.SetMinSize(app.minSize.Width+(len(app.list)-1)*INSTANCE_WIDTH, app.minSize.Height)
+	app.window.Platform.SetSize(int(size[0])+columns*INSTANCE_WIDTH, int(size[1]))
+}
+
+// windowSize returns the size of the main layout, without the columns of
+// the other instances.
+func (app *instances) windowSize() settings.Window {
+	size := app.window.Platform.DisplaySize()
+	return settings.Window{
+		Width:  int(size[0]) - (len(app.list)-1)*INSTANCE_WIDTH,
+		Height: int(size[1]),
+	}
+}
+
+// quit stops all instances.
+func (app *instances) quit() {
+	app.setFocus(0)
+	app.setLockstep(false)
+
+	for _, instance := range app.list {
+		app.window.Renderer.ReleaseImageTexture(&instance.texture)
+		instance.session.Emulator.Quit()
+		if instance.session.Recorder.Recording() {
+			instance.session.StopRecording()
+		}
+	}
+	for _, instance := range app.list[1:] {
+		instance.session.Audio.Close()
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/inkyblackness/imgui-go/v4"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/emulator"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/gui"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/settings"
+)
+
+// keyDown tells whether a keyboard key is held down.
+func keyDown(w *gui.MasterWindow, key settings.Key) bool {
+	if key.Scancode {
+		return w.Platform.ScancodeDown(key.Code)
+	}
+
+	return imgui.IsKeyDown(key.Code)
+}
+
+// keyLabel names a keyboard key for the GUI. Physical keys are shown in
+// brackets with the character the current layout gives them.
+func keyLabel(key settings.Key) string {
+	if !key.Scancode {
+		return key.String()
+	}
+	if name := glfw.GetKeyName(glfw.KeyUnknown, key.Code); name != "" {
+		return fmt.Sprintf("[%s]", strings.ToUpper(name))
+	}
+
+	return fmt.Sprintf("[%d]", key.Code)
+}
+
+// keyLabels names all keyboard keys of a keypad key.
+func keyLabels(keys []settings.Key) string {
+	labels := make([]string, len(keys))
+	for i, key := range keys {
+		labels[i] = keyLabel(key)
+	}
+
+	return strings.Join(labels, ", ")
+}
+
+func processKeyboardEvents(w *gui.MasterWindow, app *instances) {
+	// the keypad editor takes the keyboard while it waits for a key, and
+	// text fields while they are edited
+	if bindingPad >= 0 || imgui.CurrentIO().WantTextInput() {
+		app.releaseKeys()
+		return
+	}
+
+	s := app.focused()
+	if imgui.IsKeyPressedV(int(glfw.KeyF12), false) {
+		s.Screenshot()
+	}
+	if imgui.IsKeyPressedV(int(glfw.KeyF5), false) {
+		s.QuickSave()
+	}
+	if imgui.IsKeyPressedV(int(glfw.KeyF9), false) {
+		s.QuickLoad()
+	}
+	processSpeedKeys(app)
+
+	// a keypad key is down while any of its keyboard keys is
+	keys, keys2 := s.Keys()
+	for keypad, keys := range []settings.Keys{keys, keys2} {
+		for pad, bound := range keys {
+			down := false
+			for _, key := range bound {
+				down = down || keyDown(w, key)
+			}
+			if down != app.held[keypad][pad] {
+				app.held[keypad][pad] = down
+				app.input(emulator.Input{Keypad: keypad + 1, Key: uint(pad), Pressed: down})
+			}
+		}
+	}
+}
+
+// processSpeedKeys handles the hotkeys of the speed: F1 is turbo while
+// held, F2 steps through slow motion, F3 stops and starts the VM and F4
+// advances it by a frame while it is stopped.
+func processSpeedKeys(app *instances) {
+	s := app.focused()
+	snapshot := s.Emulator.Snapshot()
+
+	if turbo := imgui.IsKeyDown(int(glfw.KeyF1)); turbo != app.turbo {
+		app.turbo = turbo
+		s.Emulator.SetTurbo(turbo)
+	}
+	if imgui.IsKeyPressedV(int(glfw.KeyF2), false) {
+		switch {
+		case snapshot.Speed > emulator.SPEED_DEFAULT/2:
+			s.Emulator.SetSpeed(emulator.SPEED_DEFAULT / 2)
+		case snapshot.Speed > emulator.SPEED_DEFAULT/4:
+			s.Emulator.SetSpeed(emulator.SPEED_DEFAULT / 4)
+		default:
+			s.Emulator.SetSpeed(emulator.SPEED_DEFAULT)
+		}
+	}
+	if imgui.IsKeyPressedV(int(glfw.KeyF3), false) {
+		if snapshot.Running {
+			s.StopVM()
+		} else {
+			s.StartVM()
+		}
+	}
+	if imgui.IsKeyPressed(int(glfw.KeyF4)) {
+		s.Emulator.Advance()
+	}
+}
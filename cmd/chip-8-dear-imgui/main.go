@@ -1,129 +1,328 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"image"
-	"image/color"
-	"strings"
+	"os"
+	"path/filepath"
 
-	"github.com/inkyblackness/imgui-go/v4"
 	"github.com/kaishuu0123/chip-8-dear-imgui/internal/audio"
 	"github.com/kaishuu0123/chip-8-dear-imgui/internal/chip8"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/emulator"
 	"github.com/kaishuu0123/chip-8-dear-imgui/internal/gui"
-	"github.com/kaishuu0123/chip-8-dear-imgui/internal/master_data"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/logger"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/palette"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/recorder"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/romdb"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/session"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/settings"
 )
 
-const (
-	MASTER_WINDOW_WIDTH  = 800
-	MASTER_WINDOW_HEIGHT = 600
-	DISPLAY_WIDTH        = 640
-	DISPLAY_HEIGHT       = 320
-)
+func resetWhenOnDrop(s *session.Session, file_name string) {
+	s.Log.Infof("rom", "Loading ROM ... (PATH: %s)", file_name)
 
-func runChip8() {
-	var foreGroundColor = color.RGBA{R: 156, G: 220, B: 254, A: 255}
-	var backGroundColor = color.RGBA{R: 50, G: 50, B: 54, A: 255}
-
-	var masterData = master_data.GetMasterDataInstance()
-	masterData.InitAllTickers()
-
-	// alias
-	var display = masterData.DisplayRGBA
-
-	masterData.RunningChip8 = true
-	for masterData.RunningChip8 {
-		select {
-		case <-masterData.VideoTicker.C:
-			for y := 0; y < int(display.Bounds().Dy()); y++ {
-				for x := 0; x < int(display.Bounds().Dx()); x++ {
-					videoX := x / 10
-					videoY := y / 10
-
-					if masterData.Chip8vm.Video[videoY][videoX] == 0 {
-						display.Set(x, y, backGroundColor)
-					} else {
-						display.Set(x, y, foreGroundColor)
-					}
-				}
-			}
-		case <-masterData.ClockTicker.C:
-			masterData.Chip8vm.Step()
-		case <-masterData.DelayTicker.C:
-			if masterData.Chip8vm.DT > 0 {
-				masterData.Chip8vm.DT--
-			}
-		case <-masterData.SoundTicker.C:
-			if masterData.Chip8vm.ST > 0 {
-				masterData.Audio.OutSineWave()
-				masterData.Chip8vm.ST--
-			}
+	vm, err := chip8.LoadFromFile(file_name, s.LoadConfig)
+	if err != nil {
+		s.Log.Errorf("rom", "Loading ROM failed. (%s)", err)
+		return
+	}
+
+	platform, base := vm.Platform, vm.Base
+	s.LoadVM(vm)
+	s.Log.Infof("rom", "Loading ROM completed. (PLATFORM: %s, BASE: %03X)", platform, base)
+
+	if path, err := filepath.Abs(file_name); err == nil {
+		s.Settings.LastROM = path
+		s.Settings.AddRecent(path)
+		s.SaveSettings()
+	}
+}
+
+// onDrop loads a single dropped ROM into the focused instance. A dropped
+// directory is opened in the ROM browser, and several files are listed
+// there.
+func onDrop(app *instances) func(names []string) {
+	return func(names []string) {
+		if len(names) > 1 {
+			app.browser.drop(names)
+			return
 		}
+
+		if info, err := os.Stat(names[0]); err == nil && info.IsDir() {
+			app.browser.browse(names[0])
+			app.browser.open = true
+			return
+		}
+		resetWhenOnDrop(app.focused(), names[0])
 	}
 }
 
-func resetWhenOnDrop(file_name string) {
-	var md = master_data.GetMasterDataInstance()
-	md.AddLogMessage(fmt.Sprintf("Loading ROM ... (PATH: %s)", file_name))
+// loadDatabase reads the ROM database for the platforms of the loaded ROMs
+// and the titles in the ROM browser, if there is one.
+func loadDatabase(s *session.Session) *romdb.Database {
+	path, err := settings.DatabasePath()
+	if err != nil {
+		return nil
+	}
+
+	db, err := romdb.Load(path)
+	switch {
+	case os.IsNotExist(err):
+		s.Log.Debugf("rom", "No ROM database. (PATH: %s)", path)
+	case err != nil:
+		s.Log.Warnf("rom", "Loading ROM database failed. (%s)", err)
+	default:
+		s.Log.Debugf("rom", "ROM database loaded with %d ROMs. (PATH: %s)", db.Len(), path)
+	}
 
-	md.StopAllTickers()
-	md.RunningChip8 = false
-	md.Chip8vm, _ = chip8.LoadFromFile(file_name)
-	md.AddLogMessage("Loading ROM completed.")
-	md.ResetAllTickers()
-	md.RunningChip8 = true
+	return db
 }
 
-func onDrop(names []string) {
-	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("%s", names[0]))
-	dropInFiles := sb.String()
-	resetWhenOnDrop(dropInFiles)
+// usageError reports an invalid command line and exits.
+func usageError(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(2)
 }
 
-func processKeyboardEvents() {
-	masterData := master_data.GetMasterDataInstance()
-	for k, v := range master_data.KeyMap {
-		if imgui.IsKeyPressed(int(k)) {
-			masterData.Chip8vm.PressKey(v)
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] [ROM]\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	platformName := flag.String("platform", "auto", "ROM platform (auto, chip8, eti660, hires, chip8x, megachip)")
+	base := flag.Uint("base", 0, "load address of ROM files, not of the boot program or a -state (0 = platform default)")
+	entry := flag.Uint("entry", 0, "entry address of ROM files, not of the boot program or a -state (0 = load address)")
+	timingName := flag.String("timing", "fixed", "instruction timing (fixed, vip)")
+	quirksName := flag.String("quirks", "modern", "quirks profile (modern, vip, schip, xochip)")
+	clock := flag.Int("clock", emulator.CLOCK_RATE, "instructions per second with fixed timing (overrides the settings)")
+	cycles := flag.Int("cycles", 0, "instructions per 60 Hz frame with fixed timing, instead of -clock")
+	scale := flag.Int("scale", session.DISPLAY_SCALE, "display scale of a 64x32 video (5-20)")
+	paletteName := flag.String("palette", "", "palette preset (overrides the settings)")
+	paused := flag.Bool("paused", false, "start with the emulator stopped")
+	fullscreen := flag.Bool("fullscreen", false, "start in fullscreen")
+	mute := flag.Bool("mute", false, "mute the buzzer (overrides the settings)")
+	seed := flag.Int64("seed", 0, "seed of the random number generator (0 = a new seed on every reset)")
+	state := flag.String("state", "", "save state to continue from, instead of a ROM")
+	inputFile := flag.String("input", "", "input file to play from the start")
+	recordInput := flag.String("record-input", "", "record the inputs to this file until exit")
+	audioName := flag.String("audio", "portaudio", "audio backend (portaudio, null, wav)")
+	audioFile := flag.String("audio-file", "", "file the wav audio backend records to")
+	record := flag.String("record", "", "record the display from the start to this file (.gif or .png sequence)")
+	recordScale := flag.Int("record-scale", 4, "scale of recordings (1-10)")
+	headless := flag.Bool("headless", false, "run the ROM given as argument without a window")
+	frames := flag.Int("frames", 600, "number of 60 Hz frames to run in headless mode")
+	logLevelName := flag.String("log-level", "info", "least severe messages written to stderr in headless mode (debug, info, warn, error)")
+	flag.Parse()
+
+	romPath := flag.Arg(0)
+	switch {
+	case flag.NArg() > 1:
+		usageError(fmt.Errorf("Only one ROM can be given, not %d", flag.NArg()))
+	case romPath != "" && *state != "":
+		usageError(errors.New("A ROM and -state can't be given together, the save state contains the ROM"))
+	case *headless && romPath == "" && *state == "":
+		usageError(errors.New("No ROM or -state given to run in headless mode"))
+	}
+
+	platform, err := chip8.ParsePlatform(*platformName)
+	if err != nil {
+		usageError(err)
+	}
+	timing, err := chip8.ParseTiming(*timingName)
+	if err != nil {
+		usageError(err)
+	}
+	quirks, err := chip8.ParseQuirks(*quirksName)
+	if err != nil {
+		usageError(err)
+	}
+	logLevel, err := logger.ParseLevel(*logLevelName)
+	if err != nil {
+		usageError(err)
+	}
+	if err := session.SetDisplayScale(*scale); err != nil {
+		usageError(err)
+	}
+
+	var inputs []emulator.Input
+	if *inputFile != "" {
+		if inputs, err = emulator.LoadInputs(*inputFile); err != nil {
+			usageError(err)
 		}
-		if imgui.IsKeyReleased(int(k)) {
-			masterData.Chip8vm.ReleasedKey(v)
+	}
+
+	log := logger.New()
+	if *headless {
+		log.SetOutput(os.Stderr, logLevel)
+	}
+	s := session.New(nil, nil, log)
+	s.LoadConfig = chip8.Config{
+		Platform: platform,
+		Base:     *base,
+		Entry:    *entry,
+		Timing:   timing,
+		Quirks:   quirks,
+		Seed:     *seed,
+	}
+
+	s.RecordScale = *recordScale
+	if *record != "" {
+		s.RecordFormat, err = recorder.ParseFormat(filepath.Ext(*record))
+		if err != nil {
+			usageError(err)
 		}
+		s.RecordPath = *record
 	}
-}
 
-func main() {
-	masterData := master_data.GetMasterDataInstance()
+	*s.Settings, err = settings.Load()
+	if err != nil {
+		s.Log.Warnf("settings", "Failed to load settings. (%s)", err)
+	}
+	db := loadDatabase(s)
+	s.LoadConfig.Database = db
+
+	// flags given on the command line win over the settings
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "clock":
+			s.Settings.Flags.Clock = clock
+		case "cycles":
+			perSecond := *cycles * 60
+			s.Settings.Flags.Clock = &perSecond
+		case "mute":
+			s.Settings.Flags.Mute = mute
+		case "palette":
+			preset, err := palette.Find(*paletteName)
+			if err != nil {
+				usageError(err)
+			}
+			s.Settings.Flags.Palette = &preset
+		}
+	})
+	if isFlagSet("clock") && isFlagSet("cycles") {
+		usageError(errors.New("-clock and -cycles can't be given together"))
+	}
+	if err := s.Settings.Flags.Validate(); err != nil {
+		usageError(err)
+	}
+	s.Filter.SetSettings(s.Settings.Video)
 
-	masterData.DisplayRGBA = image.NewRGBA(image.Rect(0, 0, DISPLAY_WIDTH, DISPLAY_HEIGHT))
+	if *headless {
+		wavPath := ""
+		if *audioName == "wav" {
+			if *audioFile == "" {
+				usageError(errors.New("WAV audio needs a file to write to, given with -audio-file"))
+			}
+			wavPath = *audioFile
+		}
+		if err := runHeadless(s, romPath, *state, wavPath, *frames, inputs); err != nil {
+			log.Errorf("main", "%s", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	masterData.Window = gui.NewMasterWindow("CHIP-8 with Dear ImGUI", MASTER_WINDOW_WIDTH, MASTER_WINDOW_HEIGHT, 0)
-	var window *gui.MasterWindow = masterData.Window
-	window.SetDropCallback(onDrop)
+	displayWidth, displayHeight := session.DisplaySize(64, 32)
+	s.SetDisplay(image.NewRGBA(image.Rect(0, 0, displayWidth, displayHeight)))
 
-	vm, _ := chip8.LoadROM(chip8.Boot, false)
-	masterData.Chip8vm = vm
+	// leave room for the panels next to and below the display
+	minSize := settings.Window{
+		Width:  max(settings.WINDOW_WIDTH, session.DISPLAY_WIDTH+160),
+		Height: max(settings.WINDOW_HEIGHT, session.DISPLAY_HEIGHT+280),
+	}
+	window := gui.NewMasterWindow("CHIP-8 with Dear ImGUI",
+		max(s.Settings.Window.Width, minSize.Width), max(s.Settings.Window.Height, minSize.Height), 0)
+	window.Platform.SetMinSize(minSize.Width, minSize.Height)
+	if *fullscreen {
+		window.Platform.SetFullscreen()
+	}
+	s.Clipboard = window.Platform.SetClipboardText
 
-	masterData.Audio, _ = audio.NewAudio()
-	err := masterData.Audio.Start()
+	s.Audio, err = audio.Open(*audioName, *audioFile)
 	if err != nil {
-		panic(err)
+		s.Log.Warnf("audio", "Audio is muted. (%s)", err)
 	}
-	defer masterData.Audio.Close()
+	defer s.Audio.Close()
+	s.Audio.SetSettings(s.Settings.Audio)
 
-	masterData.AddLogMessage("CHIP-8 with Dear ImGUI initialized!")
-	masterData.AddLogMessage("Please drag and drop CHIP-8's ROM (Binary data ONLY)")
+	vm, _ := chip8.LoadROM(chip8.Boot, chip8.Config{Timing: timing, Quirks: quirks, Seed: *seed})
+	s.Emulator = emulator.New(vm, s.Audio)
+	s.Emulator.OnFrame = s.HandleFrame
+	s.Emulator.OnError = s.HandleError
+	s.LoadVM(vm)
 
-	go runChip8()
+	s.Log.Infof("main", "CHIP-8 with Dear ImGUI initialized!")
+	switch {
+	case *state != "":
+		s.LoadState(*state)
+	case romPath != "":
+		resetWhenOnDrop(s, romPath)
+	case s.Settings.LastROM != "":
+		resetWhenOnDrop(s, s.Settings.LastROM)
+	default:
+		s.Log.Infof("main", "Please drag and drop CHIP-8's ROM (Binary data ONLY)")
+	}
+
+	if inputs != nil {
+		s.Emulator.Play(inputs)
+	}
+	if *recordInput != "" {
+		s.Emulator.RecordInputs()
+	}
+	if *paused {
+		s.Emulator.Pause()
+	}
+	if s.RecordPath != "" {
+		s.StartRecording()
+	}
+
+	s.Emulator.Start()
+
+	app := newInstances(window, minSize, s)
+	app.fullscreen = *fullscreen
+	app.browser = newROMBrowser(db)
+	window.SetDropCallback(onDrop(app))
 
 	for !window.Platform.ShouldStop() {
 		window.Platform.ProcessEvents()
-		processKeyboardEvents()
+		processKeyboardEvents(window, app)
 
-		texture, _ := window.Renderer.CreateImageTexture(masterData.DisplayRGBA)
+		// upload the displays only when the emulators have drawn new ones
+		app.update()
 
-		renderGUI(window, &texture)
+		renderGUI(window, app)
 	}
 
+	if *recordInput != "" {
+		if err := emulator.SaveInputs(*recordInput, s.Emulator.StopRecordingInputs()); err != nil {
+			fmt.Fprintf(os.Stderr, "Saving inputs failed. (%s)\n", err)
+		}
+	}
+	if size := app.windowSize(); !app.fullscreen && size != s.Settings.Window && size.Validate() == nil {
+		s.Settings.Window = size
+		s.SaveSettings()
+	}
+	app.quit()
+
 	// ToDO: Signal Handling (use NotifyContext?)
 }
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+
+	return set
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -5,8 +5,11 @@ import (
 	"image/color"
 
 	"github.com/inkyblackness/imgui-go/v4"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/chip8"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/emulator"
 	"github.com/kaishuu0123/chip-8-dear-imgui/internal/gui"
-	"github.com/kaishuu0123/chip-8-dear-imgui/internal/master_data"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/session"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/settings"
 )
 
 var (
@@ -17,7 +20,7 @@ var (
 	millisPerSecond float32 = 1000.0
 )
 
-func drawKeyPad(fontSize *imgui.Vec2) {
+func drawKeyPad(w *gui.MasterWindow, fontSize *imgui.Vec2, keys settings.Keys) {
 	{
 		drawList := imgui.WindowDrawList()
 		cursorPos := imgui.CursorScreenPos()
@@ -27,9 +30,19 @@ func drawKeyPad(fontSize *imgui.Vec2) {
 		buttonColor := imgui.CurrentStyle().Color(imgui.StyleColorButton)
 		buttonActiveColor := imgui.CurrentStyle().Color(imgui.StyleColorButtonActive)
 
-		for index, key := range master_data.KeyMapOrder {
+		for index, pad := range settings.KEYPAD_ORDER {
+			down := false
+			for _, key := range keys[pad] {
+				down = down || keyDown(w, key)
+			}
+			label := ""
+			if len(keys[pad]) > 0 {
+				label = keyLabel(keys[pad][0])
+			}
+			labelSize := imgui.CalcTextSize(label, false, 0)
+
 			var rectColor color.RGBA
-			if imgui.IsKeyPressed(int(key)) {
+			if down {
 				rectColor = color.RGBA{
 					R: uint8(buttonActiveColor.X * 255),
 					G: uint8(buttonActiveColor.Y * 255),
@@ -66,8 +79,8 @@ func drawKeyPad(fontSize *imgui.Vec2) {
 			drawList.AddText(
 				pos.Plus(
 					imgui.Vec2{
-						X: (fontSize.Y * 1.5 / 2) - (fontSize.X / 2),
-						Y: (fontSize.Y * 1.5 / 2) - (fontSize.Y / 2),
+						X: (fontSize.Y * 1.5 / 2) - (labelSize.X / 2),
+						Y: (fontSize.Y * 1.5 / 2) - (labelSize.Y / 2),
 					}),
 				imgui.Packed(color.RGBA{
 					R: uint8(textColor.X * 255),
@@ -75,115 +88,274 @@ func drawKeyPad(fontSize *imgui.Vec2) {
 					B: uint8(textColor.Z * 255),
 					A: uint8(textColor.W * 255),
 				}),
-				string(key),
+				label,
 			)
 		}
 	}
 }
 
-func renderGUI(w *gui.MasterWindow, texture *imgui.TextureID) {
-	var masterData = master_data.GetMasterDataInstance()
+// drawRegisters draws the registers and the stack of the VM.
+func drawRegisters(w *gui.MasterWindow, snapshot *emulator.Snapshot) {
+	imgui.PushFont(w.FontsData[1])
+	imgui.BeginTable("V[X] & Stack table", 2)
+	imgui.TableSetupColumn("V[x]")
+	imgui.TableSetupColumn("Stack")
+	imgui.TableHeadersRow()
+
+	for rowIndex := 0; rowIndex < len(snapshot.V); rowIndex++ {
+		imgui.TableNextRow()
+
+		imgui.TableSetColumnIndex(0)
+		imgui.Text(fmt.Sprintf("V%X: %02X", rowIndex, snapshot.V[rowIndex]))
+		imgui.TableSetColumnIndex(1)
+		imgui.Text(fmt.Sprintf("S%X: %04X", rowIndex, snapshot.Stack[rowIndex]))
+	}
+	imgui.EndTable()
+	imgui.Separator()
+	imgui.BeginTable("MISC registers table", 2)
+	imgui.TableNextRow()
+	imgui.TableSetColumnIndex(0)
+	imgui.Text(fmt.Sprintf("PC: %04X", snapshot.PC))
+	imgui.TableSetColumnIndex(1)
+	imgui.Text(fmt.Sprintf("DT: %02X", snapshot.DT))
+	imgui.TableNextRow()
+	imgui.TableSetColumnIndex(0)
+	imgui.Text(fmt.Sprintf("SP: %02X", snapshot.SP))
+	imgui.TableSetColumnIndex(1)
+	imgui.Text(fmt.Sprintf("ST: %02X", snapshot.ST))
+	imgui.TableNextRow()
+	imgui.TableSetColumnIndex(0)
+	imgui.Text(fmt.Sprintf(" I: %04X", snapshot.I))
+	imgui.EndTable()
+	imgui.PopFont()
+}
+
+// drawRunControls draws the buttons that run the VM and its speed.
+// speedPresets are the slow motion speeds and the normal speed.
+var speedPresets = []struct {
+	label string
+	speed int
+}{
+	{"1/4", emulator.SPEED_DEFAULT / 4},
+	{"1/2", emulator.SPEED_DEFAULT / 2},
+	{"1x", emulator.SPEED_DEFAULT},
+}
+
+func drawRunControls(s *session.Session, snapshot *emulator.Snapshot) {
+	if imgui.Button("RESET") {
+		s.ResetVM()
+	}
+	imgui.SameLine()
+	if snapshot.Running {
+		if imgui.Button("STOP") {
+			s.StopVM()
+		}
+	} else {
+		if imgui.Button("START") {
+			s.StartVM()
+		}
+		imgui.SameLine()
+		if imgui.Button("ADVANCE") {
+			s.Emulator.Advance()
+		}
+	}
+	imgui.SameLine()
+	speed := int32(snapshot.Speed)
+	imgui.PushItemWidth(INSTANCE_WIDTH / 2)
+	if imgui.SliderIntV("Speed", &speed, emulator.MIN_SPEED, emulator.MAX_SPEED, "%d%%", imgui.SliderFlagsLogarithmic) {
+		s.Emulator.SetSpeed(int(speed))
+	}
+	imgui.PopItemWidth()
+
+	for i, preset := range speedPresets {
+		if i > 0 {
+			imgui.SameLine()
+		}
+		if imgui.Button(preset.label) {
+			s.Emulator.SetSpeed(preset.speed)
+		}
+	}
+	if snapshot.Turbo {
+		imgui.SameLine()
+		imgui.Text("TURBO")
+	}
+
+	// the clock rate is chosen as instructions per frame
+	cycles := int32(snapshot.ClockRate / 60)
+	imgui.PushItemWidth(INSTANCE_WIDTH / 2)
+	if imgui.SliderIntV("Cycles", &cycles, emulator.MIN_CYCLES, emulator.MAX_CYCLES, "%d / frame", imgui.SliderFlagsLogarithmic) {
+		s.SetClock(int(cycles)*60, s.HasROMClock())
+	}
+	if imgui.IsItemDeactivatedAfterEdit() {
+		s.SaveSettings()
+	}
+	imgui.PopItemWidth()
+	imgui.SameLine()
+	perROM := s.HasROMClock()
+	if imgui.Checkbox("This ROM", &perROM) {
+		if perROM {
+			s.SetClock(snapshot.ClockRate, true)
+		} else {
+			s.ClearROMClock()
+		}
+		s.SaveSettings()
+	}
+}
+
+// drawInstance draws the column of an instance after the first, with its
+// display at half size. The columns start at origin.
+func drawInstance(w *gui.MasterWindow, app *instances, index int, origin imgui.Vec2, height float32) {
+	instance := app.list[index]
+	s := instance.session
+	snapshot := s.Emulator.Snapshot()
+
+	imgui.SetNextWindowPos(origin.Plus(imgui.Vec2{X: float32(index-1) * INSTANCE_WIDTH}))
+	imgui.SetNextWindowSize(imgui.Vec2{X: INSTANCE_WIDTH, Y: height - origin.Y})
+	imgui.BeginV(fmt.Sprintf("Instance #%d", index+1), nil, windowFlags)
+	if imgui.IsWindowFocusedV(imgui.FocusedFlagsRootAndChildWindows) {
+		app.setFocus(index)
+	}
+
+	// the display is scaled down to fit the column
+	scale := float32(INSTANCE_WIDTH) / float32(session.DISPLAY_WIDTH)
+	displayWidth, displayHeight := float32(INSTANCE_WIDTH), float32(session.DISPLAY_HEIGHT)*scale
+	imageBounds := s.DisplayBounds()
+	imageSize := imgui.Vec2{X: float32(imageBounds.Dx()) * scale, Y: float32(imageBounds.Dy()) * scale}
+	cursorPos := imgui.CursorPos()
+	imgui.SetCursorPos(cursorPos.Plus(imgui.Vec2{
+		X: (displayWidth - imageSize.X) / 2,
+		Y: (displayHeight - imageSize.Y) / 2,
+	}))
+	imgui.Image(instance.texture.ID, imageSize)
+	imgui.SetCursorPos(cursorPos.Plus(imgui.Vec2{X: 0, Y: displayHeight}))
+
+	imgui.Text(instanceStatus(app, index, snapshot))
+	if instance.diff != "" {
+		imgui.Text(instance.diff)
+	}
+	drawRunControls(s, snapshot)
+	if imgui.Button("CLOSE") {
+		app.close(index)
+	}
+	imgui.Separator()
+	drawRegisters(w, snapshot)
+	imgui.End()
+}
+
+// instanceStatus describes the state of an instance in one line.
+func instanceStatus(app *instances, index int, snapshot *emulator.Snapshot) string {
+	status := "STOP"
+	if snapshot.Running {
+		status = "RUNNING"
+	}
+	status = fmt.Sprintf("Status: %s  Frame: %d", status, snapshot.Frames)
+	if len(app.list) > 1 && index == app.focus {
+		status += "  [INPUT]"
+	}
+
+	return status
+}
+
+func renderGUI(w *gui.MasterWindow, app *instances) {
+	var s = app.list[0].session
+	var snapshot = s.Emulator.Snapshot()
+
+	// the main layout fills the window left of the other instances
+	windowSize := w.Platform.DisplaySize()
+	windowWidth := windowSize[0] - float32(len(app.list)-1)*INSTANCE_WIDTH
+	windowHeight := windowSize[1]
 
 	w.Platform.NewFrame()
 	imgui.NewFrame()
 
 	// DON'T FORGET call PopStyleVar when PushStyleVar called
 	imgui.PushStyleVarFloat(imgui.StyleVarWindowRounding, 0.0)
+	menuBarHeight := drawMenuBar(app)
+	drawSettingsWindows(w, app.focused())
+	drawROMBrowser(app)
+
 	imgui.PushStyleVarVec2(imgui.StyleVarWindowPadding, imgui.Vec2{X: 0, Y: 0})
 
-	imgui.SetNextWindowPos(imgui.Vec2{X: 0, Y: 0})
+	imgui.SetNextWindowPos(imgui.Vec2{X: 0, Y: menuBarHeight})
 
 	imgui.BeginV("Display", nil, windowFlags|imgui.WindowFlagsAlwaysAutoResize)
-	imgui.Image(*texture, imgui.Vec2{X: master_data.DISPLAY_WIDTH, Y: master_data.DISPLAY_HEIGHT})
+	if imgui.IsWindowFocused() {
+		app.setFocus(0)
+	}
+	// keep the display area fixed and center the image in it, so that the
+	// layout doesn't move when the emulated resolution changes
+	displayWidth, displayHeight := float32(session.DISPLAY_WIDTH), float32(session.DISPLAY_HEIGHT)
+	imageBounds := s.DisplayBounds()
+	imageSize := imgui.Vec2{X: float32(imageBounds.Dx()), Y: float32(imageBounds.Dy())}
+	cursorPos := imgui.CursorPos()
+	imgui.SetCursorPos(cursorPos.Plus(imgui.Vec2{
+		X: (displayWidth - imageSize.X) / 2,
+		Y: (displayHeight - imageSize.Y) / 2,
+	}))
+	imgui.Image(app.list[0].texture.ID, imageSize)
+	imgui.SetCursorPos(cursorPos)
+	imgui.Dummy(imgui.Vec2{X: displayWidth, Y: displayHeight})
 	displaySize := imgui.WindowSize()
 	imgui.End()
 
 	// Pop StyleVarWindowPadding
 	imgui.PopStyleVar()
 
-	imgui.SetNextWindowPos(imgui.Vec2{X: 0, Y: displaySize.Y})
+	imgui.SetNextWindowPos(imgui.Vec2{X: 0, Y: menuBarHeight + displaySize.Y})
 	imgui.SetNextWindowSize(imgui.Vec2{X: displaySize.X, Y: 0})
 
 	imgui.BeginV("Status & Controls", nil, windowFlags)
-	if masterData.RunningChip8 {
-		imgui.Text("Status: RUNNING")
-	} else {
-		imgui.Text("Status: STOP")
+	if imgui.IsWindowFocused() {
+		app.setFocus(0)
 	}
+	imgui.Text(instanceStatus(app, 0, snapshot))
 
-	if imgui.Button("RESET") {
-		masterData.ResetVM()
+	drawRunControls(s, snapshot)
+	imgui.SameLine()
+	if imgui.Button("SCREENSHOT") {
+		s.Screenshot()
 	}
 	imgui.SameLine()
-	if masterData.RunningChip8 {
-		if imgui.Button("STOP") {
-			masterData.StopVM()
-		}
-	} else {
-		if imgui.Button("START") {
-			masterData.StartVM()
+	vipTiming := snapshot.Timing == chip8.TimingVIP
+	if imgui.Checkbox("VIP timing", &vipTiming) {
+		timing := chip8.TimingFixed
+		if vipTiming {
+			timing = chip8.TimingVIP
 		}
+		s.Emulator.SetTiming(timing)
+		s.LoadConfig.Timing = timing
 	}
 	statusControlSize := imgui.WindowSize()
 	imgui.End()
 
-	imgui.SetNextWindowPos(imgui.Vec2{X: 0, Y: displaySize.Y + statusControlSize.Y})
-	imgui.SetNextWindowSize(imgui.Vec2{X: displaySize.X, Y: master_data.MASTER_WINDOW_HEIGHT - displaySize.Y - statusControlSize.Y})
+	imgui.SetNextWindowPos(imgui.Vec2{X: 0, Y: menuBarHeight + displaySize.Y + statusControlSize.Y})
+	imgui.SetNextWindowSize(imgui.Vec2{X: displaySize.X, Y: windowHeight - menuBarHeight - displaySize.Y - statusControlSize.Y})
 	imgui.BeginV("Message", nil, windowFlags)
-	for _, message := range masterData.LogMessages {
-		imgui.Text(message)
-	}
+	drawMessages(s)
 	imgui.End()
 
-	imgui.SetNextWindowPos(imgui.Vec2{X: displaySize.X, Y: 0})
-	imgui.SetNextWindowSize(imgui.Vec2{X: master_data.MASTER_WINDOW_WIDTH - displaySize.X, Y: displaySize.Y})
+	imgui.SetNextWindowPos(imgui.Vec2{X: displaySize.X, Y: menuBarHeight})
+	imgui.SetNextWindowSize(imgui.Vec2{X: windowWidth - displaySize.X, Y: displaySize.Y})
 
 	imgui.BeginV("Internal of CHIP-8", nil, windowFlags)
-	imgui.PushFont(masterData.Window.FontsData[1])
-	imgui.BeginTable("V[X] & Stack table", 2)
-	imgui.TableSetupColumn("V[x]")
-	imgui.TableSetupColumn("Stack")
-	imgui.TableHeadersRow()
-
-	for rowIndex := 0; rowIndex < len(masterData.Chip8vm.V); rowIndex++ {
-		imgui.TableNextRow()
-
-		imgui.TableSetColumnIndex(0)
-		imgui.Text(fmt.Sprintf("V%X: %02X", rowIndex, masterData.Chip8vm.V[rowIndex]))
-		imgui.TableSetColumnIndex(1)
-		imgui.Text(fmt.Sprintf("S%X: %04X", rowIndex, masterData.Chip8vm.Stack[rowIndex]))
+	if imgui.IsWindowFocused() {
+		app.setFocus(0)
 	}
-	imgui.EndTable()
-	imgui.Separator()
-	imgui.BeginTable("MISC registers table", 2)
-	imgui.TableNextRow()
-	imgui.TableSetColumnIndex(0)
-	imgui.Text(fmt.Sprintf("PC: %04X", masterData.Chip8vm.PC))
-	imgui.TableSetColumnIndex(1)
-	imgui.Text(fmt.Sprintf("DT: %02X", masterData.Chip8vm.DT))
-	imgui.TableNextRow()
-	imgui.TableSetColumnIndex(0)
-	imgui.Text(fmt.Sprintf("SP: %02X", masterData.Chip8vm.SP))
-	imgui.TableSetColumnIndex(1)
-	imgui.Text(fmt.Sprintf("ST: %02X", masterData.Chip8vm.ST))
-	imgui.TableNextRow()
-	imgui.TableSetColumnIndex(0)
-	imgui.Text(fmt.Sprintf(" I: %04X", masterData.Chip8vm.I))
-	imgui.EndTable()
-	imgui.PopFont()
+	drawRegisters(w, snapshot)
 	imgui.End()
 
 	fontSize := imgui.CalcTextSize("A", false, 0.0)
-	imgui.SetNextWindowPos(imgui.Vec2{X: displaySize.X, Y: displaySize.Y})
-	imgui.SetNextWindowSize(imgui.Vec2{X: master_data.MASTER_WINDOW_WIDTH - displaySize.X, Y: fontSize.Y * 10})
+	imgui.SetNextWindowPos(imgui.Vec2{X: displaySize.X, Y: menuBarHeight + displaySize.Y})
+	imgui.SetNextWindowSize(imgui.Vec2{X: windowWidth - displaySize.X, Y: fontSize.Y * 10})
 	imgui.BeginV("KeyPad", nil, windowFlags)
 	// draw KeyPad
-	drawKeyPad(&fontSize)
+	keys, _ := app.focused().Keys()
+	drawKeyPad(w, &fontSize, keys)
 	keyPadWindowSize := imgui.WindowSize()
 	imgui.End()
 
-	imgui.SetNextWindowPos(imgui.Vec2{X: displaySize.X, Y: displaySize.Y + keyPadWindowSize.Y})
-	imgui.SetNextWindowSize(imgui.Vec2{X: master_data.MASTER_WINDOW_WIDTH - displaySize.X, Y: master_data.MASTER_WINDOW_HEIGHT - displaySize.Y - keyPadWindowSize.Y})
+	imgui.SetNextWindowPos(imgui.Vec2{X: displaySize.X, Y: menuBarHeight + displaySize.Y + keyPadWindowSize.Y})
+	imgui.SetNextWindowSize(imgui.Vec2{X: windowWidth - displaySize.X, Y: windowHeight - menuBarHeight - displaySize.Y - keyPadWindowSize.Y})
 	imgui.BeginV("Debug", nil, windowFlags)
 	imgui.Text("[PERF]")
 	imgui.Text(fmt.Sprintf("%.3f ms/frame",
@@ -193,6 +365,10 @@ func renderGUI(w *gui.MasterWindow, texture *imgui.TextureID) {
 		imgui.CurrentIO().Framerate()))
 	imgui.End()
 
+	for index := 1; index < len(app.list); index++ {
+		drawInstance(w, app, index, imgui.Vec2{X: windowWidth, Y: menuBarHeight}, windowHeight)
+	}
+
 	// Pop StyleVarWindowRounding
 	imgui.PopStyleVar()
 
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"github.com/inkyblackness/imgui-go/v4"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/logger"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/session"
+)
+
+var (
+	logFilter     = logger.Filter{Level: logger.LevelInfo}
+	logAutoScroll = true
+)
+
+// levelColors are the text colours of the levels that stand out.
+var levelColors = map[logger.Level]imgui.Vec4{
+	logger.LevelDebug: {X: 0.6, Y: 0.6, Z: 0.6, W: 1},
+	logger.LevelWarn:  {X: 1, Y: 0.8, Z: 0.3, W: 1},
+	logger.LevelError: {X: 1, Y: 0.4, Z: 0.4, W: 1},
+}
+
+// drawMessages draws the log entries that match the filter, below the
+// controls of the filter.
+func drawMessages(s *session.Session) {
+	imgui.PushItemWidth(80)
+	if imgui.BeginCombo("##level", logFilter.Level.String()) {
+		for _, level := range logger.Levels {
+			if imgui.SelectableV(level.String(), level == logFilter.Level, 0, imgui.Vec2{}) {
+				logFilter.Level = level
+			}
+		}
+		imgui.EndCombo()
+	}
+	imgui.PopItemWidth()
+	imgui.SameLine()
+	imgui.PushItemWidth(160)
+	imgui.InputTextWithHint("##filter", "Filter", &logFilter.Text)
+	imgui.PopItemWidth()
+	imgui.SameLine()
+	imgui.Checkbox("Auto-scroll", &logAutoScroll)
+	imgui.SameLine()
+	if imgui.Button("CLEAR") {
+		s.Log.Clear()
+	}
+	imgui.SameLine()
+	if imgui.Button("COPY") {
+		s.CopyLog(logFilter)
+	}
+	imgui.SameLine()
+	if imgui.Button("EXPORT") {
+		s.ExportLog(logFilter)
+	}
+	imgui.Separator()
+
+	var entries []logger.Entry
+	for _, entry := range s.Log.Entries() {
+		if logFilter.Match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	imgui.BeginChildV("Entries", imgui.Vec2{}, false, imgui.WindowFlagsHorizontalScrollbar)
+	// only the entries in view are drawn
+	var clipper imgui.ListClipper
+	clipper.Begin(len(entries))
+	for clipper.Step() {
+		for _, entry := range entries[clipper.DisplayStart:clipper.DisplayEnd] {
+			if color, ok := levelColors[entry.Level]; ok {
+				imgui.PushStyleColor(imgui.StyleColorText, color)
+				imgui.Text(entry.String())
+				imgui.PopStyleColor()
+			} else {
+				imgui.Text(entry.String())
+			}
+		}
+	}
+	clipper.End()
+
+	// follow new entries unless scrolled up
+	if logAutoScroll && imgui.ScrollY() >= imgui.ScrollMaxY() {
+		imgui.SetScrollHereY(1)
+	}
+	imgui.EndChild()
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/inkyblackness/imgui-go/v4"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/audio"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/filter"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/gui"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/gui/framework_for_imgui"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/palette"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/recorder"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/session"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/settings"
+)
+
+var (
+	settingsWindowFlags imgui.WindowFlags = imgui.WindowFlagsNoCollapse |
+		imgui.WindowFlagsAlwaysAutoResize
+
+	showAudioSettings bool
+	showVideoSettings bool
+	showPalette       bool
+	showKeypad        bool
+
+	// bindingPad is the keypad key waiting for a keyboard key, or -1
+	bindingPad = -1
+	// bindingKeypad is the keypad shown in the keypad editor
+	bindingKeypad = 1
+	// bindScancodes binds keys by their physical position
+	bindScancodes bool
+)
+
+// drawMenuBar draws the main menu bar and returns its height.
+func drawMenuBar(app *instances) float32 {
+	var height float32
+
+	if imgui.BeginMainMenuBar() {
+		drawFileMenu(app)
+		if imgui.BeginMenu("Settings") {
+			if imgui.MenuItemV("Audio", "", showAudioSettings, true) {
+				showAudioSettings = !showAudioSettings
+			}
+			if imgui.MenuItemV("Video", "", showVideoSettings, true) {
+				showVideoSettings = !showVideoSettings
+			}
+			if imgui.MenuItemV("Palette", "", showPalette, true) {
+				showPalette = !showPalette
+			}
+			if imgui.MenuItemV("Keypad", "", showKeypad, true) {
+				showKeypad = !showKeypad
+			}
+			imgui.EndMenu()
+		}
+		drawStateMenu(app.focused())
+		drawRecordMenu(app.focused())
+		drawInstancesMenu(app)
+		height = imgui.WindowSize().Y
+		imgui.EndMainMenuBar()
+	}
+
+	return height
+}
+
+func drawFileMenu(app *instances) {
+	if !imgui.BeginMenu("File") {
+		return
+	}
+
+	s := app.focused()
+	if imgui.MenuItem("Open ROM...") {
+		app.browser.open = true
+	}
+	if imgui.BeginMenuV("Recent", len(s.Settings.Recent) > 0) {
+		for _, path := range s.Settings.Recent {
+			if imgui.MenuItem(path) {
+				resetWhenOnDrop(s, path)
+			}
+		}
+		imgui.EndMenu()
+	}
+	if imgui.BeginMenuV("Favourites", len(s.Settings.Favorites) > 0) {
+		for _, path := range s.Settings.Favorites {
+			if imgui.MenuItem(path) {
+				resetWhenOnDrop(s, path)
+			}
+		}
+		imgui.EndMenu()
+	}
+	imgui.EndMenu()
+}
+
+func drawInstancesMenu(app *instances) {
+	if !imgui.BeginMenu("Instances") {
+		return
+	}
+
+	if imgui.MenuItem("Open instance") {
+		app.open()
+	}
+	if imgui.MenuItemV("Close instance", "", false, app.focus > 0) {
+		app.close(app.focus)
+	}
+	imgui.Separator()
+	if imgui.MenuItemV("Mirror input", "", app.mirror, true) {
+		app.mirror = !app.mirror
+	}
+	if imgui.MenuItemV("Lockstep", "", app.lockstep != nil, true) {
+		app.setLockstep(app.lockstep == nil)
+	}
+
+	imgui.EndMenu()
+}
+
+func drawStateMenu(s *session.Session) {
+	if !imgui.BeginMenu("State") {
+		return
+	}
+
+	if imgui.MenuItemV("Save state", "F5", false, true) {
+		s.QuickSave()
+	}
+	if imgui.MenuItemV("Load state", "F9", false, true) {
+		s.QuickLoad()
+	}
+	imgui.EndMenu()
+}
+
+func drawRecordMenu(s *session.Session) {
+	if !imgui.BeginMenu("Record") {
+		return
+	}
+
+	if s.Recorder.Recording() {
+		if imgui.MenuItem("Stop recording") {
+			s.StopRecording()
+		}
+	} else if imgui.MenuItem("Start recording") {
+		s.StartRecording()
+	}
+	if imgui.MenuItem("Save last 10 seconds") {
+		s.SaveReplay()
+	}
+
+	imgui.Separator()
+	for _, format := range []recorder.Format{recorder.FormatGIF, recorder.FormatPNG} {
+		label := "Animated GIF"
+		if format == recorder.FormatPNG {
+			label = "PNG sequence"
+		}
+		if imgui.MenuItemV(label, "", format == s.RecordFormat, true) {
+			s.RecordFormat = format
+		}
+	}
+
+	scale := int32(s.RecordScale)
+	if imgui.SliderInt("Scale", &scale, recorder.MIN_SCALE, recorder.MAX_SCALE) {
+		s.RecordScale = int(scale)
+	}
+
+	imgui.Separator()
+	if imgui.MenuItemV("Screenshot", "F12", false, true) {
+		s.Screenshot()
+	}
+	if imgui.MenuItem("Copy display as text") {
+		s.CopyTextArt()
+	}
+
+	screenshotScale := int32(s.ScreenshotScale)
+	if imgui.SliderInt("Screenshot scale", &screenshotScale, recorder.MIN_SCALE, recorder.MAX_SCALE) {
+		s.ScreenshotScale = int(screenshotScale)
+	}
+
+	imgui.EndMenu()
+}
+
+func drawSettingsWindows(w *gui.MasterWindow, s *session.Session) {
+	drawAudioSettings(s)
+	drawVideoSettings(s)
+	drawPaletteEditor(s)
+	drawKeypadEditor(w, s)
+}
+
+func drawAudioSettings(s *session.Session) {
+	if !showAudioSettings {
+		return
+	}
+
+	imgui.SetNextWindowPosV(imgui.Vec2{X: 120, Y: 80}, imgui.ConditionAppearing, imgui.Vec2{})
+	if imgui.BeginV("Audio", &showAudioSettings, settingsWindowFlags) {
+		settings := s.Settings.Audio
+		changed := false
+		save := false
+
+		if imgui.BeginCombo("Preset", "Select ...") {
+			for _, preset := range audio.Presets {
+				if imgui.Selectable(preset.Name) {
+					settings = preset.Settings
+					changed = true
+					save = true
+				}
+			}
+			imgui.EndCombo()
+		}
+
+		if imgui.BeginCombo("Waveform", settings.Waveform.String()) {
+			for _, waveform := range audio.Waveforms {
+				if imgui.SelectableV(waveform.String(), waveform == settings.Waveform, 0, imgui.Vec2{}) {
+					settings.Waveform = waveform
+					changed = true
+					save = true
+				}
+			}
+			imgui.EndCombo()
+		}
+
+		frequency := float32(settings.Frequency)
+		if imgui.SliderFloatV("Frequency", &frequency, 20, 4000, "%.0f Hz", imgui.SliderFlagsLogarithmic) {
+			settings.Frequency = float64(frequency)
+			changed = true
+		}
+		save = save || imgui.IsItemDeactivatedAfterEdit()
+
+		volume := float32(settings.Volume)
+		if imgui.SliderFloatV("Volume", &volume, 0, 1, "%.2f", imgui.SliderFlagsNone) {
+			settings.Volume = float64(volume)
+			changed = true
+		}
+		save = save || imgui.IsItemDeactivatedAfterEdit()
+
+		attack := float32(settings.Attack * 1000)
+		if imgui.SliderFloatV("Attack", &attack, 0, 100, "%.0f ms", imgui.SliderFlagsNone) {
+			settings.Attack = float64(attack) / 1000
+			changed = true
+		}
+		save = save || imgui.IsItemDeactivatedAfterEdit()
+
+		release := float32(settings.Release * 1000)
+		if imgui.SliderFloatV("Release", &release, 0, 100, "%.0f ms", imgui.SliderFlagsNone) {
+			settings.Release = float64(release) / 1000
+			changed = true
+		}
+		save = save || imgui.IsItemDeactivatedAfterEdit()
+
+		if imgui.Checkbox("Mute", &settings.Mute) {
+			changed = true
+			save = true
+		}
+
+		imgui.Separator()
+		testing := imgui.Button("Hold to test")
+		s.Audio.SetTestTone(testing || imgui.IsItemActive())
+
+		if changed {
+			s.SetAudioSettings(settings)
+		}
+		if save {
+			s.SaveSettings()
+		}
+	}
+	imgui.End()
+}
+
+func drawVideoSettings(s *session.Session) {
+	if !showVideoSettings {
+		return
+	}
+
+	imgui.SetNextWindowPosV(imgui.Vec2{X: 160, Y: 120}, imgui.ConditionAppearing, imgui.Vec2{})
+	if imgui.BeginV("Video", &showVideoSettings, settingsWindowFlags) {
+		settings := s.Settings.Video
+		changed := false
+		save := false
+
+		if imgui.BeginCombo("Filter", filterLabel(settings.Mode)) {
+			for _, mode := range filter.Modes {
+				if imgui.SelectableV(filterLabel(mode), mode == settings.Mode, 0, imgui.Vec2{}) {
+					settings.Mode = mode
+					changed = true
+					save = true
+				}
+			}
+			imgui.EndCombo()
+		}
+
+		switch settings.Mode {
+		case filter.ModePhosphor:
+			phosphor := float32(settings.Phosphor)
+			if imgui.SliderFloatV("Persistence", &phosphor, 0, 1, "%.2f", imgui.SliderFlagsNone) {
+				settings.Phosphor = float64(phosphor)
+				changed = true
+			}
+			save = save || imgui.IsItemDeactivatedAfterEdit()
+		case filter.ModeBlend:
+			blend := float32(settings.Blend)
+			if imgui.SliderFloatV("Strength", &blend, 0, 1, "%.2f", imgui.SliderFlagsNone) {
+				settings.Blend = float64(blend)
+				changed = true
+			}
+			save = save || imgui.IsItemDeactivatedAfterEdit()
+		case filter.ModeOR:
+			frames := int32(settings.Frames)
+			if imgui.SliderInt("Frames", &frames, filter.MIN_OR_FRAMES, filter.MAX_OR_FRAMES) {
+				settings.Frames = int(frames)
+				changed = true
+			}
+			save = save || imgui.IsItemDeactivatedAfterEdit()
+		}
+
+		if changed {
+			s.SetVideoSettings(settings)
+		}
+		if save {
+			s.SaveSettings()
+		}
+	}
+	imgui.End()
+}
+
+func filterLabel(mode filter.Mode) string {
+	switch mode {
+	case filter.ModePhosphor:
+		return "Phosphor fade"
+	case filter.ModeBlend:
+		return "Frame blending"
+	case filter.ModeOR:
+		return "OR of last frames"
+	default:
+		return "None"
+	}
+}
+
+var paletteColorLabels = [palette.COLORS]string{"Background", "Foreground", "Plane 2", "Both planes"}
+
+func drawPaletteEditor(s *session.Session) {
+	if !showPalette {
+		return
+	}
+
+	imgui.SetNextWindowPosV(imgui.Vec2{X: 200, Y: 160}, imgui.ConditionAppearing, imgui.Vec2{})
+	if imgui.BeginV("Palette", &showPalette, settingsWindowFlags) {
+		colors := s.Palette()
+		perROM := s.HasROMPalette()
+		changed := false
+		save := false
+
+		if imgui.BeginCombo("Preset", colors.Name) {
+			for _, preset := range palette.Presets {
+				if imgui.SelectableV(preset.Name, preset.Name == colors.Name, 0, imgui.Vec2{}) {
+					colors = preset
+					changed = true
+					save = true
+				}
+			}
+			imgui.EndCombo()
+		}
+
+		for i, label := range paletteColorLabels {
+			c := colors.Colors[i]
+			edit := [3]float32{float32(c.R) / 255, float32(c.G) / 255, float32(c.B) / 255}
+			if imgui.ColorEdit3(label, &edit) {
+				colors.Colors[i].R = uint8(edit[0]*255 + 0.5)
+				colors.Colors[i].G = uint8(edit[1]*255 + 0.5)
+				colors.Colors[i].B = uint8(edit[2]*255 + 0.5)
+				colors.Name = "Custom"
+				changed = true
+			}
+			save = save || imgui.IsItemDeactivatedAfterEdit()
+		}
+
+		imgui.Separator()
+		if imgui.Checkbox("Only for this ROM", &perROM) {
+			if perROM {
+				changed = true
+			} else {
+				s.ClearROMPalette()
+			}
+			save = true
+		}
+
+		if changed {
+			s.SetPalette(colors, perROM)
+		}
+		if save {
+			s.SaveSettings()
+		}
+	}
+	imgui.End()
+}
+
+// drawKeypadEditor binds keyboard keys to the keypad: clicking a keypad key
+// waits for a keyboard key, which is added to it, or removed if it was
+// already bound.
+func drawKeypadEditor(w *gui.MasterWindow, s *session.Session) {
+	if !showKeypad {
+		bindingPad = -1
+		return
+	}
+
+	imgui.SetNextWindowPosV(imgui.Vec2{X: 220, Y: 180}, imgui.ConditionAppearing, imgui.Vec2{})
+	if imgui.BeginV("Keypad", &showKeypad, settingsWindowFlags) {
+		keys1, keys2 := s.Keys()
+		keys := keys1.Copy()
+		if bindingKeypad == 2 {
+			keys = keys2.Copy()
+		}
+		perROM := s.HasROMKeys()
+		changed := false
+
+		if imgui.RadioButton("Keypad 1", bindingKeypad == 1) {
+			bindingKeypad, bindingPad = 1, -1
+		}
+		imgui.SameLine()
+		if imgui.RadioButton("Keypad 2 (CHIP-8X)", bindingKeypad == 2) {
+			bindingKeypad, bindingPad = 2, -1
+		}
+
+		if bindingKeypad == 1 && imgui.BeginCombo("Preset", keys.Preset()) {
+			for _, preset := range settings.KeysPresets {
+				if imgui.SelectableV(preset.Name, preset.Name == keys.Preset(), 0, imgui.Vec2{}) {
+					keys = preset.Keys.Copy()
+					changed = true
+				}
+			}
+			imgui.EndCombo()
+		}
+
+		for index, pad := range settings.KEYPAD_ORDER {
+			if index%4 != 0 {
+				imgui.SameLine()
+			}
+			label := keyLabels(keys[pad])
+			if int(pad) == bindingPad {
+				label = "..."
+			}
+			if imgui.ButtonV(fmt.Sprintf("%X\n%s##pad%X", pad, label, pad), imgui.Vec2{X: 96, Y: 40}) {
+				bindingPad = int(pad)
+			}
+		}
+
+		if bindingPad < 0 {
+			imgui.Text("Click a keypad key, then press a keyboard key to bind to it.")
+		} else {
+			imgui.Text(fmt.Sprintf("Press a key to bind to %X, or again to unbind it.", bindingPad))
+			imgui.Text("Esc cancels, Delete unbinds all keys.")
+			if press, ok := w.Platform.KeyPressed(); ok {
+				changed = bindKey(s, &keys, press) || changed
+			}
+		}
+		imgui.Checkbox("Bind physical keys (scancodes)", &bindScancodes)
+
+		imgui.Separator()
+		if imgui.Checkbox("Only for this ROM", &perROM) {
+			if perROM {
+				changed = true
+			} else {
+				s.ClearROMKeys()
+				s.SaveSettings()
+			}
+		}
+
+		if changed {
+			s.SetKeys(bindingKeypad, keys, perROM)
+			s.SaveSettings()
+		}
+	}
+	imgui.End()
+}
+
+// bindKey changes the keys of bindingPad for a key pressed while waiting
+// and tells whether they changed.
+func bindKey(s *session.Session, keys *settings.Keys, press framework_for_imgui.KeyPress) bool {
+	pad := uint(bindingPad)
+	bindingPad = -1
+
+	switch press.Key {
+	case int(glfw.KeyEscape):
+		return false
+	case int(glfw.KeyDelete):
+		keys[pad] = nil
+		return true
+	}
+
+	key := settings.Key{Code: press.Key}
+	if bindScancodes {
+		key = settings.Key{Code: press.Scancode, Scancode: true}
+	}
+	if err := key.Validate(); err != nil {
+		s.Log.Warnf("keypad", "Binding key failed. (%s)", err)
+		return false
+	}
+
+	bound, ok := keys.Bound(key)
+	keys.Unbind(key)
+	if !ok || bound != pad {
+		keys[pad] = append(keys[pad], key)
+	}
+
+	return true
+}
@@ -2,96 +2,245 @@ package audio
 
 import (
 	"math"
+	"sync/atomic"
+)
+
+const (
+	// ENVELOPE_SECONDS is how long the buzzer takes to fade in and out by
+	// default, which keeps it from clicking when it starts and stops.
+	ENVELOPE_SECONDS = 0.005
 
-	"github.com/gordonklaus/portaudio"
+	BUZZER_TONE   = 440.0 // A4
+	BUZZER_VOLUME = 0.3
+
+	PATTERN_SAMPLES = 128
 )
 
+// pattern is a looped 1-bit waveform (XO-CHIP) that replaces the buzzer.
+type pattern struct {
+	bits [PATTERN_SAMPLES / 8]byte
+	rate float64
+}
+
 type Audio struct {
-	stream         *portaudio.Stream
-	SampleRate     float64
-	OutputChannels int
-	Channel        chan float32
+	backend    Backend
+	SampleRate float64
 
 	StreamBufferLengthPerFrame int
 
-	PhaseL float64
-	PhaseR float64
+	// buzzer is 1 while the buzzer sounds. It is written by the emulation
+	// and read by the callback, atomically.
+	buzzer int32
+	// test is 1 while the buzzer is tried out from the settings
+	test int32
+
+	// samples carries streamed sound (MEGA-CHIP samples) to the callback
+	samples *ringBuffer
+
+	// settings holds the current Settings, swapped atomically
+	settings atomic.Value
+
+	// pattern holds the current *pattern, or nil for the plain buzzer
+	pattern atomic.Value
+	// tone holds the frequency of the buzzer set by the program, or 0 for
+	// the frequency of the settings
+	tone atomic.Value
+
+	// owned by the callback
+	phase        float64
+	patternPhase float64
+	gain         float64
+	noise        uint32
+	scratch      []float32
 }
 
-func NewAudio() (*Audio, error) {
-	err := portaudio.Initialize()
-	if err != nil {
-		return nil, err
-	}
-
+func NewAudio(backend Backend) *Audio {
 	a := Audio{}
-	a.Channel = make(chan float32, 44100)
-	return &a, nil
+	a.backend = backend
+	a.samples = newRingBuffer(44100)
+	a.settings.Store(DefaultSettings())
+	a.pattern.Store((*pattern)(nil))
+	a.tone.Store(0.0)
+	a.noise = 1
+	return &a
 }
 
-func (a *Audio) Close() {
-	a.stream.Stop()
-	a.stream.Close()
-	portaudio.Terminate()
+func (a *Audio) Close() error {
+	return a.backend.Close()
 }
 
 func (a *Audio) Start() error {
-	host, err := portaudio.DefaultHostApi()
-	if err != nil {
-		return err
+	a.SampleRate = a.backend.SampleRate()
+
+	a.StreamBufferLengthPerFrame = int((a.SampleRate * (1 / 60.0))) + 1
+
+	a.phase = 0
+	a.gain = 0
+
+	return a.backend.Start(a.Callback)
+}
+
+// Backend returns the backend the audio is played on.
+func (a *Audio) Backend() Backend {
+	return a.backend
+}
+
+// Callback generates the buzzer and mixes in streamed samples. It never
+// waits for the emulation: missing samples are played as silence.
+func (a *Audio) Callback(out [][]float32) {
+	frames := len(out[0])
+	if len(a.scratch) < frames {
+		a.scratch = make([]float32, frames)
 	}
-	parameters := portaudio.HighLatencyParameters(nil, host.DefaultOutputDevice)
-	stream, err := portaudio.OpenStream(parameters, a.Callback)
-	if err != nil {
-		return err
+	streamed := a.samples.Read(a.scratch[:frames])
+
+	settings := a.Settings()
+	current := a.pattern.Load().(*pattern)
+
+	target := 0.0
+	if atomic.LoadInt32(&a.buzzer) != 0 || atomic.LoadInt32(&a.test) != 0 {
+		target = 1
 	}
-	if err := stream.Start(); err != nil {
-		return err
+	attackStep := envelopeStep(settings.Attack, a.SampleRate)
+	releaseStep := envelopeStep(settings.Release, a.SampleRate)
+	frequency := settings.Frequency
+	if tone := a.tone.Load().(float64); tone > 0 {
+		frequency = tone
 	}
+	step := frequency / a.SampleRate
 
-	a.stream = stream
-	a.SampleRate = parameters.SampleRate
-	a.OutputChannels = parameters.Output.Channels
+	volume := settings.Volume
+	if settings.Mute {
+		volume = 0
+	}
 
-	a.StreamBufferLengthPerFrame = int((a.SampleRate * (1 / 60.0))) + 1
+	for i := 0; i < frames; i++ {
+		if a.gain < target {
+			a.gain = math.Min(a.gain+attackStep, target)
+		} else if a.gain > target {
+			a.gain = math.Max(a.gain-releaseStep, target)
+		}
+
+		// the phase keeps running while silent, so the wave continues
+		// seamlessly between frames
+		var wave float64
+		if current != nil {
+			index := int(a.patternPhase)
+			wave = -1
+			if current.bits[index/8]>>(7-index%8)&0x1 != 0 {
+				wave = 1
+			}
+			a.patternPhase = math.Mod(a.patternPhase+current.rate/a.SampleRate, PATTERN_SAMPLES)
+		} else {
+			wave = oscillate(settings.Waveform, a.phase, &a.noise)
+			_, a.phase = math.Modf(a.phase + step)
+		}
+		output := float32(wave * a.gain * volume)
+
+		if i < streamed && !settings.Mute {
+			output += a.scratch[i]
+		}
+
+		for channel := range out {
+			out[channel][i] = output
+		}
+	}
+}
+
+func envelopeStep(seconds float64, sampleRate float64) float64 {
+	if seconds <= 0 {
+		return 1
+	}
 
-	a.PhaseL = 0
-	return nil
+	return 1 / (seconds * sampleRate)
 }
 
-func (a *Audio) Stop() error {
-	return a.stream.Close()
+// Settings returns the current buzzer settings.
+func (a *Audio) Settings() Settings {
+	return a.settings.Load().(Settings)
 }
 
-func (a *Audio) Callback(out [][]float32) {
-	var output float32 = 0
-	for i := range out[0] {
-		select {
-		case sample := <-a.Channel:
-			output = sample
-		default:
-			output = 0
-		}
-		out[0][i] = output
-		out[1][i] = output
+// SetSettings changes the buzzer settings. It is safe to call from any
+// goroutine; the callback picks them up with its next buffer.
+func (a *Audio) SetSettings(settings Settings) {
+	a.settings.Store(settings)
+}
+
+// SetBuzzer turns the buzzer on or off. It is safe to call from any
+// goroutine and never blocks.
+func (a *Audio) SetBuzzer(on bool) {
+	var value int32
+	if on {
+		value = 1
 	}
+
+	atomic.StoreInt32(&a.buzzer, value)
 }
 
-func (a *Audio) OutSineWave() {
-	tone := 440.0 // A4
-	step := tone / a.SampleRate
+// SetTestTone sounds the buzzer regardless of the emulation, to try out
+// the settings.
+func (a *Audio) SetTestTone(on bool) {
+	var value int32
+	if on {
+		value = 1
+	}
 
-	buffer := make([]float32, a.StreamBufferLengthPerFrame)
-	var volume float32 = 0.3
+	atomic.StoreInt32(&a.test, value)
+}
+
+// SetPattern makes the buzzer play a 128 sample 1-bit pattern at rate
+// samples per second instead of the configured waveform.
+func (a *Audio) SetPattern(bits [PATTERN_SAMPLES / 8]byte, rate float64) {
+	a.pattern.Store(&pattern{bits: bits, rate: rate})
+}
 
-	// Create Sine wave + Volume
-	for i, _ := range buffer {
-		sine := math.Sin(2.0 * math.Pi * a.PhaseL)
-		_, a.PhaseL = math.Modf(a.PhaseL + step)
-		buffer[i] = float32(sine) * volume
+// ClearPattern returns the buzzer to the configured waveform.
+func (a *Audio) ClearPattern() {
+	a.pattern.Store((*pattern)(nil))
+}
+
+// SetTone makes the buzzer sound at frequency (CHIP-8X) instead of the
+// frequency of the settings.
+func (a *Audio) SetTone(frequency float64) {
+	a.tone.Store(frequency)
+}
+
+// ClearTone returns the buzzer to the frequency of the settings.
+func (a *Audio) ClearTone() {
+	a.tone.Store(0.0)
+}
+
+// OutSample outputs one frame of an unsigned 8-bit sample starting at
+// position (in source samples). It returns the position to continue from
+// and false once a non-looping sample has finished. Whatever doesn't fit
+// into the queue is dropped instead of blocking.
+func (a *Audio) OutSample(data []byte, rate float64, position float64, loop bool) (float64, bool) {
+	if len(data) == 0 || rate <= 0 {
+		return position, false
 	}
 
-	for i, _ := range buffer {
-		a.Channel <- buffer[i]
+	step := rate / a.SampleRate
+
+	buffer := make([]float32, 0, a.StreamBufferLengthPerFrame)
+	var volume float32 = 0.3
+
+	playing := true
+	for len(buffer) < a.StreamBufferLengthPerFrame {
+		index := int(position)
+		if index >= len(data) {
+			if !loop {
+				playing = false
+				break
+			}
+			position -= float64(len(data))
+			index = int(position)
+		}
+
+		buffer = append(buffer, (float32(data[index])-128)/128*volume)
+		position += step
 	}
+
+	a.samples.Write(buffer)
+
+	return position, playing
 }
@@ -0,0 +1,48 @@
+package audio
+
+import "fmt"
+
+// Backend plays what Audio renders. Start hands it the function that
+// renders the next buffer; the backend decides when to call it.
+type Backend interface {
+	Start(render func(out [][]float32)) error
+	SampleRate() float64
+	Close() error
+}
+
+// NewBackend creates a backend by name: "portaudio", "null" or "wav".
+// path is the file the WAV backend writes to.
+func NewBackend(name string, path string) (Backend, error) {
+	switch name {
+	case "", "portaudio":
+		return NewPortAudioBackend()
+	case "null":
+		return NewNullBackend(), nil
+	case "wav":
+		if path == "" {
+			return nil, fmt.Errorf("WAV audio needs a file to write to")
+		}
+		return NewWAVBackend(path, true)
+	}
+
+	return nil, fmt.Errorf("Unknown audio backend: %s", name)
+}
+
+// Open creates and starts the named backend. When that fails it falls back
+// to a silent null backend, so the returned Audio is always usable; the
+// error tells why the fallback was taken.
+func Open(name string, path string) (*Audio, error) {
+	backend, err := NewBackend(name, path)
+	if err == nil {
+		a := NewAudio(backend)
+		if err = a.Start(); err == nil {
+			return a, nil
+		}
+		a.Close()
+	}
+
+	a := NewAudio(NewNullBackend())
+	a.Start()
+
+	return a, err
+}
@@ -0,0 +1,22 @@
+package audio
+
+// NullBackend discards all audio.
+type NullBackend struct {
+	sampleRate float64
+}
+
+func NewNullBackend() *NullBackend {
+	return &NullBackend{sampleRate: 44100}
+}
+
+func (b *NullBackend) Start(render func(out [][]float32)) error {
+	return nil
+}
+
+func (b *NullBackend) SampleRate() float64 {
+	return b.sampleRate
+}
+
+func (b *NullBackend) Close() error {
+	return nil
+}
@@ -0,0 +1,58 @@
+package audio
+
+import "github.com/gordonklaus/portaudio"
+
+// PortAudioBackend plays audio on the default output device.
+type PortAudioBackend struct {
+	stream     *portaudio.Stream
+	parameters portaudio.StreamParameters
+}
+
+func NewPortAudioBackend() (*PortAudioBackend, error) {
+	err := portaudio.Initialize()
+	if err != nil {
+		return nil, err
+	}
+
+	host, err := portaudio.DefaultHostApi()
+	if err != nil {
+		portaudio.Terminate()
+		return nil, err
+	}
+	if host.DefaultOutputDevice == nil {
+		portaudio.Terminate()
+		return nil, portaudio.NoDefaultOutputDevice
+	}
+
+	return &PortAudioBackend{
+		parameters: portaudio.HighLatencyParameters(nil, host.DefaultOutputDevice),
+	}, nil
+}
+
+func (b *PortAudioBackend) Start(render func(out [][]float32)) error {
+	stream, err := portaudio.OpenStream(b.parameters, render)
+	if err != nil {
+		return err
+	}
+	if err := stream.Start(); err != nil {
+		stream.Close()
+		return err
+	}
+
+	b.stream = stream
+	return nil
+}
+
+func (b *PortAudioBackend) SampleRate() float64 {
+	return b.parameters.SampleRate
+}
+
+func (b *PortAudioBackend) Close() error {
+	if b.stream != nil {
+		b.stream.Stop()
+		b.stream.Close()
+		b.stream = nil
+	}
+
+	return portaudio.Terminate()
+}
@@ -0,0 +1,62 @@
+package audio
+
+import "sync/atomic"
+
+// ringBuffer is a lock-free queue of samples for exactly one writer and one
+// reader. Writes never block: samples that don't fit are dropped.
+type ringBuffer struct {
+	buffer []float32
+	mask   uint64
+
+	// read and write only ever grow and are accessed atomically
+	read  uint64
+	write uint64
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	capacity := 1
+	for capacity < size {
+		capacity <<= 1
+	}
+
+	return &ringBuffer{
+		buffer: make([]float32, capacity),
+		mask:   uint64(capacity - 1),
+	}
+}
+
+// Write queues as many samples as fit and returns how many that were.
+func (r *ringBuffer) Write(samples []float32) int {
+	read := atomic.LoadUint64(&r.read)
+	write := atomic.LoadUint64(&r.write)
+
+	n := uint64(len(r.buffer)) - (write - read)
+	if uint64(len(samples)) < n {
+		n = uint64(len(samples))
+	}
+
+	for i := uint64(0); i < n; i++ {
+		r.buffer[(write+i)&r.mask] = samples[i]
+	}
+	atomic.StoreUint64(&r.write, write+n)
+
+	return int(n)
+}
+
+// Read dequeues up to len(out) samples and returns how many were read.
+func (r *ringBuffer) Read(out []float32) int {
+	read := atomic.LoadUint64(&r.read)
+	write := atomic.LoadUint64(&r.write)
+
+	n := write - read
+	if uint64(len(out)) < n {
+		n = uint64(len(out))
+	}
+
+	for i := uint64(0); i < n; i++ {
+		out[i] = r.buffer[(read+i)&r.mask]
+	}
+	atomic.StoreUint64(&r.read, read+n)
+
+	return int(n)
+}
@@ -0,0 +1,145 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Waveform is the shape of the buzzer tone.
+type Waveform int
+
+const (
+	WaveformSine Waveform = iota
+	WaveformSquare
+	WaveformTriangle
+	WaveformSawtooth
+	WaveformNoise
+)
+
+var waveformNames = []string{"sine", "square", "triangle", "sawtooth", "noise"}
+
+// Waveforms lists every waveform, in the order shown to the user.
+var Waveforms = []Waveform{WaveformSine, WaveformSquare, WaveformTriangle, WaveformSawtooth, WaveformNoise}
+
+func (w Waveform) String() string {
+	if int(w) >= 0 && int(w) < len(waveformNames) {
+		return waveformNames[w]
+	}
+
+	return waveformNames[WaveformSine]
+}
+
+func ParseWaveform(name string) (Waveform, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i, waveformName := range waveformNames {
+		if waveformName == name {
+			return Waveform(i), nil
+		}
+	}
+
+	return WaveformSine, fmt.Errorf("Unknown waveform: %s", name)
+}
+
+func (w Waveform) MarshalText() ([]byte, error) {
+	return []byte(w.String()), nil
+}
+
+func (w *Waveform) UnmarshalText(text []byte) error {
+	waveform, err := ParseWaveform(string(text))
+	if err != nil {
+		return err
+	}
+
+	*w = waveform
+	return nil
+}
+
+// Settings describe how the buzzer sounds. Attack and Release are the
+// seconds it takes to fade in and out.
+type Settings struct {
+	Waveform  Waveform `json:"waveform"`
+	Frequency float64  `json:"frequency"`
+	Volume    float64  `json:"volume"`
+	Mute      bool     `json:"mute"`
+	Attack    float64  `json:"attack"`
+	Release   float64  `json:"release"`
+}
+
+// Preset is a named set of buzzer settings.
+type Preset struct {
+	Name     string
+	Settings Settings
+}
+
+func DefaultSettings() Settings {
+	return Settings{
+		Waveform:  WaveformSine,
+		Frequency: BUZZER_TONE,
+		Volume:    BUZZER_VOLUME,
+		Attack:    ENVELOPE_SECONDS,
+		Release:   ENVELOPE_SECONDS,
+	}
+}
+
+// Presets are the built-in buzzer settings. "VIP authentic" is the square
+// wave of the COSMAC VIP's tone generator, which switched on and off hard.
+var Presets = []Preset{
+	{Name: "Default", Settings: DefaultSettings()},
+	{Name: "VIP authentic", Settings: Settings{
+		Waveform:  WaveformSquare,
+		Frequency: 1400,
+		Volume:    0.2,
+		Attack:    0.001,
+		Release:   0.001,
+	}},
+	{Name: "Soft", Settings: Settings{
+		Waveform:  WaveformTriangle,
+		Frequency: 440,
+		Volume:    0.3,
+		Attack:    0.02,
+		Release:   0.05,
+	}},
+}
+
+// Validate checks that the settings are in range.
+func (s Settings) Validate() error {
+	if int(s.Waveform) < 0 || int(s.Waveform) >= len(waveformNames) {
+		return fmt.Errorf("Invalid waveform: %d", s.Waveform)
+	}
+	if s.Frequency < 20 || s.Frequency > 20000 {
+		return fmt.Errorf("Buzzer frequency must be between 20 and 20000 Hz, not %g", s.Frequency)
+	}
+	if s.Volume < 0 || s.Volume > 1 {
+		return fmt.Errorf("Volume must be between 0 and 1, not %g", s.Volume)
+	}
+	if s.Attack < 0 || s.Attack > 1 || s.Release < 0 || s.Release > 1 {
+		return fmt.Errorf("Attack and release must be between 0 and 1 seconds")
+	}
+
+	return nil
+}
+
+// oscillate returns the waveform at phase (0 <= phase < 1) in -1..1.
+// noise is the state of the noise generator.
+func oscillate(waveform Waveform, phase float64, noise *uint32) float64 {
+	switch waveform {
+	case WaveformSquare:
+		if phase < 0.5 {
+			return 1
+		}
+		return -1
+	case WaveformTriangle:
+		return 1 - 4*math.Abs(phase-0.5)
+	case WaveformSawtooth:
+		return 2*phase - 1
+	case WaveformNoise:
+		// xorshift, so the callback doesn't need the locked global source
+		*noise ^= *noise << 13
+		*noise ^= *noise >> 17
+		*noise ^= *noise << 5
+		return float64(*noise)/math.MaxUint32*2 - 1
+	default:
+		return math.Sin(2.0 * math.Pi * phase)
+	}
+}
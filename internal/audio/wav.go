@@ -0,0 +1,172 @@
+package audio
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	WAV_SAMPLE_RATE = 44100
+	WAV_CHANNELS    = 2
+	WAV_HEADER_SIZE = 44
+
+	// WAV_FRAME_SAMPLES is the length of a 60 Hz frame in samples
+	WAV_FRAME_SAMPLES = WAV_SAMPLE_RATE / 60
+)
+
+// WAVBackend records audio to a 16-bit PCM WAV file. A realtime backend
+// pulls audio 60 times a second, so a recording is as long as the session.
+// Otherwise nothing is recorded until WriteFrame is called, once for every
+// emulated frame, which follows the emulation at any speed.
+type WAVBackend struct {
+	file     *os.File
+	realtime bool
+
+	render  func(out [][]float32)
+	ticker  *time.Ticker
+	done    chan struct{}
+	wg      sync.WaitGroup
+	out     [][]float32
+	data    []byte
+	written uint32
+	err     error
+}
+
+func NewWAVBackend(path string, realtime bool) (*WAVBackend, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &WAVBackend{file: file, realtime: realtime}
+	b.out = make([][]float32, WAV_CHANNELS)
+	for channel := range b.out {
+		b.out[channel] = make([]float32, WAV_FRAME_SAMPLES)
+	}
+	b.data = make([]byte, WAV_FRAME_SAMPLES*WAV_CHANNELS*2)
+
+	if err := b.writeHeader(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (b *WAVBackend) Start(render func(out [][]float32)) error {
+	b.render = render
+	if !b.realtime {
+		return nil
+	}
+
+	b.ticker = time.NewTicker(time.Second / 60)
+	b.done = make(chan struct{})
+
+	b.wg.Add(1)
+	go b.run()
+
+	return nil
+}
+
+func (b *WAVBackend) SampleRate() float64 {
+	return WAV_SAMPLE_RATE
+}
+
+// Close stops recording and finishes the file.
+func (b *WAVBackend) Close() error {
+	if b.done != nil {
+		close(b.done)
+		b.wg.Wait()
+		b.ticker.Stop()
+		b.done = nil
+	}
+
+	if b.file == nil {
+		return b.err
+	}
+
+	if err := b.writeHeader(); err != nil && b.err == nil {
+		b.err = err
+	}
+	if err := b.file.Close(); err != nil && b.err == nil {
+		b.err = err
+	}
+	b.file = nil
+
+	return b.err
+}
+
+func (b *WAVBackend) run() {
+	defer b.wg.Done()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-b.ticker.C:
+			b.writeFrame()
+		}
+	}
+}
+
+// WriteFrame renders and records the audio of one 60 Hz frame. It is only
+// for backends that aren't realtime, which record nothing on their own.
+func (b *WAVBackend) WriteFrame() error {
+	if b.realtime || b.render == nil || b.file == nil {
+		return nil
+	}
+
+	b.writeFrame()
+
+	return b.err
+}
+
+func (b *WAVBackend) writeFrame() {
+	b.render(b.out)
+
+	for i := 0; i < WAV_FRAME_SAMPLES; i++ {
+		for channel := range b.out {
+			sample := math.Max(-1, math.Min(1, float64(b.out[channel][i])))
+			binary.LittleEndian.PutUint16(b.data[(i*WAV_CHANNELS+channel)*2:], uint16(int16(sample*math.MaxInt16)))
+		}
+	}
+
+	if b.err != nil {
+		return
+	}
+	if _, err := b.file.Write(b.data); err != nil {
+		b.err = err
+		return
+	}
+	b.written += uint32(len(b.data))
+}
+
+// writeHeader writes the RIFF header for the data written so far and
+// returns to the end of the file.
+func (b *WAVBackend) writeHeader() error {
+	header := make([]byte, WAV_HEADER_SIZE)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], WAV_HEADER_SIZE-8+b.written)
+	copy(header[8:], "WAVE")
+	copy(header[12:], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16)
+	binary.LittleEndian.PutUint16(header[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(header[22:], WAV_CHANNELS)
+	binary.LittleEndian.PutUint32(header[24:], WAV_SAMPLE_RATE)
+	binary.LittleEndian.PutUint32(header[28:], WAV_SAMPLE_RATE*WAV_CHANNELS*2)
+	binary.LittleEndian.PutUint16(header[32:], WAV_CHANNELS*2)
+	binary.LittleEndian.PutUint16(header[34:], 16)
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], b.written)
+
+	if _, err := b.file.WriteAt(header, 0); err != nil {
+		return err
+	}
+	_, err := b.file.Seek(0, io.SeekEnd)
+
+	return err
+}
@@ -0,0 +1,329 @@
+package chip8
+
+// CDP1802 is an RCA COSMAC CDP1802 CPU. It only runs the machine code
+// subroutines that hybrid programs call with 0NNN.
+type CDP1802 struct {
+	R  [16]uint16
+	D  byte
+	DF byte
+	P  byte
+	X  byte
+	T  byte
+	IE bool
+	Q  bool
+
+	Idle bool
+
+	// Cycles counts machine cycles (8 clock cycles each).
+	Cycles int64
+
+	// EF returns the state of the external flag lines EF1-EF4.
+	EF func(n byte) bool
+	// Out receives the bus value of OUT 1-7.
+	Out func(port byte, value byte)
+	// In supplies the bus value of INP 1-7.
+	In func(port byte) byte
+}
+
+func (cpu *CDP1802) read(memory []byte, address uint16) byte {
+	return memory[int(address)%len(memory)]
+}
+
+func (cpu *CDP1802) write(memory []byte, address uint16, value byte) {
+	memory[int(address)%len(memory)] = value
+}
+
+func (cpu *CDP1802) fetch(memory []byte) byte {
+	b := cpu.read(memory, cpu.R[cpu.P])
+	cpu.R[cpu.P]++
+
+	return b
+}
+
+func (cpu *CDP1802) ef(n byte) bool {
+	if cpu.EF == nil {
+		return false
+	}
+
+	return cpu.EF(n)
+}
+
+// add sets D to a + b + carry and DF to the carry out.
+func (cpu *CDP1802) add(a, b, carry byte) {
+	sum := uint(a) + uint(b) + uint(carry)
+
+	cpu.D = byte(sum)
+	cpu.DF = byte(sum >> 8)
+}
+
+// sub sets D to a - b - borrow and DF to 1 when there was no borrow.
+func (cpu *CDP1802) sub(a, b, borrow byte) {
+	cpu.add(a, ^b, 1-borrow)
+}
+
+func (cpu *CDP1802) shortBranch(memory []byte, condition bool) {
+	if condition {
+		address := cpu.read(memory, cpu.R[cpu.P])
+		cpu.R[cpu.P] = cpu.R[cpu.P]&0xFF00 | uint16(address)
+	} else {
+		cpu.R[cpu.P]++
+	}
+}
+
+func (cpu *CDP1802) longBranch(memory []byte, condition bool) {
+	if condition {
+		high := cpu.read(memory, cpu.R[cpu.P])
+		low := cpu.read(memory, cpu.R[cpu.P]+1)
+		cpu.R[cpu.P] = uint16(high)<<8 | uint16(low)
+	} else {
+		cpu.R[cpu.P] += 2
+	}
+}
+
+func (cpu *CDP1802) longSkip(condition bool) {
+	if condition {
+		cpu.R[cpu.P] += 2
+	}
+}
+
+// Step executes a single instruction.
+func (cpu *CDP1802) Step(memory []byte) {
+	if cpu.Idle {
+		cpu.Cycles += 1
+		return
+	}
+
+	instruction := cpu.fetch(memory)
+	i := instruction >> 4
+	n := instruction & 0xF
+
+	rx := &cpu.R[cpu.X]
+
+	cpu.Cycles += 2
+
+	switch i {
+	case 0x0:
+		if n == 0 {
+			// IDL
+			cpu.Idle = true
+		} else {
+			// LDN
+			cpu.D = cpu.read(memory, cpu.R[n])
+		}
+	case 0x1:
+		// INC
+		cpu.R[n]++
+	case 0x2:
+		// DEC
+		cpu.R[n]--
+	case 0x3:
+		// BR, BQ, BZ, BDF, B1-B4 and their negations
+		var condition bool
+		switch n & 0x7 {
+		case 0x0:
+			condition = true
+		case 0x1:
+			condition = cpu.Q
+		case 0x2:
+			condition = cpu.D == 0
+		case 0x3:
+			condition = cpu.DF == 1
+		default:
+			condition = cpu.ef(n&0x7 - 3)
+		}
+		if n&0x8 != 0 {
+			condition = !condition
+		}
+		cpu.shortBranch(memory, condition)
+	case 0x4:
+		// LDA
+		cpu.D = cpu.read(memory, cpu.R[n])
+		cpu.R[n]++
+	case 0x5:
+		// STR
+		cpu.write(memory, cpu.R[n], cpu.D)
+	case 0x6:
+		switch {
+		case n == 0x0:
+			// IRX
+			*rx++
+		case n < 0x8:
+			// OUT
+			if cpu.Out != nil {
+				cpu.Out(n, cpu.read(memory, *rx))
+			}
+			*rx++
+		case n == 0x8:
+			// not defined on the 1802
+		default:
+			// INP
+			var value byte
+			if cpu.In != nil {
+				value = cpu.In(n - 8)
+			}
+			cpu.write(memory, *rx, value)
+			cpu.D = value
+		}
+	case 0x7:
+		switch n {
+		case 0x0, 0x1:
+			// RET, DIS
+			t := cpu.read(memory, *rx)
+			*rx++
+			cpu.X = t >> 4
+			cpu.P = t & 0xF
+			cpu.IE = n == 0x0
+		case 0x2:
+			// LDXA
+			cpu.D = cpu.read(memory, *rx)
+			*rx++
+		case 0x3:
+			// STXD
+			cpu.write(memory, *rx, cpu.D)
+			*rx--
+		case 0x4:
+			// ADC
+			cpu.add(cpu.read(memory, *rx), cpu.D, cpu.DF)
+		case 0x5:
+			// SDB
+			cpu.sub(cpu.read(memory, *rx), cpu.D, 1-cpu.DF)
+		case 0x6:
+			// SHRC
+			carry := cpu.DF
+			cpu.DF = cpu.D & 0x1
+			cpu.D = cpu.D>>1 | carry<<7
+		case 0x7:
+			// SMB
+			cpu.sub(cpu.D, cpu.read(memory, *rx), 1-cpu.DF)
+		case 0x8:
+			// SAV
+			cpu.write(memory, *rx, cpu.T)
+		case 0x9:
+			// MARK
+			cpu.T = cpu.X<<4 | cpu.P
+			cpu.write(memory, cpu.R[2], cpu.T)
+			cpu.X = cpu.P
+			cpu.R[2]--
+		case 0xA:
+			// REQ
+			cpu.Q = false
+		case 0xB:
+			// SEQ
+			cpu.Q = true
+		case 0xC:
+			// ADCI
+			cpu.add(cpu.fetch(memory), cpu.D, cpu.DF)
+		case 0xD:
+			// SDBI
+			cpu.sub(cpu.fetch(memory), cpu.D, 1-cpu.DF)
+		case 0xE:
+			// SHLC
+			carry := cpu.DF
+			cpu.DF = cpu.D >> 7
+			cpu.D = cpu.D<<1 | carry
+		case 0xF:
+			// SMBI
+			cpu.sub(cpu.D, cpu.fetch(memory), 1-cpu.DF)
+		}
+	case 0x8:
+		// GLO
+		cpu.D = byte(cpu.R[n])
+	case 0x9:
+		// GHI
+		cpu.D = byte(cpu.R[n] >> 8)
+	case 0xA:
+		// PLO
+		cpu.R[n] = cpu.R[n]&0xFF00 | uint16(cpu.D)
+	case 0xB:
+		// PHI
+		cpu.R[n] = cpu.R[n]&0x00FF | uint16(cpu.D)<<8
+	case 0xC:
+		// long branches and skips
+		cpu.Cycles += 1
+
+		switch n {
+		case 0x0:
+			cpu.longBranch(memory, true)
+		case 0x1:
+			cpu.longBranch(memory, cpu.Q)
+		case 0x2:
+			cpu.longBranch(memory, cpu.D == 0)
+		case 0x3:
+			cpu.longBranch(memory, cpu.DF == 1)
+		case 0x4:
+			// NOP
+		case 0x5:
+			cpu.longSkip(!cpu.Q)
+		case 0x6:
+			cpu.longSkip(cpu.D != 0)
+		case 0x7:
+			cpu.longSkip(cpu.DF == 0)
+		case 0x8:
+			cpu.longSkip(true)
+		case 0x9:
+			cpu.longBranch(memory, !cpu.Q)
+		case 0xA:
+			cpu.longBranch(memory, cpu.D != 0)
+		case 0xB:
+			cpu.longBranch(memory, cpu.DF == 0)
+		case 0xC:
+			cpu.longSkip(cpu.IE)
+		case 0xD:
+			cpu.longSkip(cpu.Q)
+		case 0xE:
+			cpu.longSkip(cpu.D == 0)
+		case 0xF:
+			cpu.longSkip(cpu.DF == 1)
+		}
+	case 0xD:
+		// SEP
+		cpu.P = n
+	case 0xE:
+		// SEX
+		cpu.X = n
+	case 0xF:
+		// ALU operations on M(R(X)), or on the immediate byte for F8-FF
+		var operand byte
+		if n&0x8 != 0 {
+			if n != 0xE {
+				operand = cpu.fetch(memory)
+			}
+		} else if n != 0x6 {
+			operand = cpu.read(memory, *rx)
+		}
+
+		switch n & 0x7 {
+		case 0x0:
+			// LDX, LDI
+			cpu.D = operand
+		case 0x1:
+			// OR, ORI
+			cpu.D |= operand
+		case 0x2:
+			// AND, ANI
+			cpu.D &= operand
+		case 0x3:
+			// XOR, XRI
+			cpu.D ^= operand
+		case 0x4:
+			// ADD, ADI
+			cpu.add(operand, cpu.D, 0)
+		case 0x5:
+			// SD, SDI
+			cpu.sub(operand, cpu.D, 0)
+		case 0x6:
+			if n == 0x6 {
+				// SHR
+				cpu.DF = cpu.D & 0x1
+				cpu.D >>= 1
+			} else {
+				// SHL
+				cpu.DF = cpu.D >> 7
+				cpu.D <<= 1
+			}
+		case 0x7:
+			// SM, SMI
+			cpu.sub(cpu.D, operand, 0)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package chip8
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// run1802 runs code from address 0 with P=0 and X=2 until it goes idle.
+func run1802(t *testing.T, cpu *CDP1802, memory []byte, code []byte) {
+	t.Helper()
+	copy(memory, code)
+	for steps := 0; !cpu.Idle; steps++ {
+		if steps > 1000 {
+			t.Fatal("code did not stop")
+		}
+		cpu.Step(memory)
+	}
+}
+
+func TestCDP1802(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+		d    byte
+		df   byte
+	}{
+		{"LDI", []byte{0xF8, 0x42, 0x00}, 0x42, 0},
+		{"ADI", []byte{0xF8, 0x10, 0xFC, 0x20, 0x00}, 0x30, 0},
+		{"ADI carry", []byte{0xF8, 0xF0, 0xFC, 0x20, 0x00}, 0x10, 1},
+		{"ADCI", []byte{0xF8, 0xFF, 0xFC, 0x01, 0x7C, 0x00, 0x00}, 0x01, 0},
+		{"SDI", []byte{0xF8, 0x10, 0xFD, 0x30, 0x00}, 0x20, 1},
+		{"SDI borrow", []byte{0xF8, 0x30, 0xFD, 0x10, 0x00}, 0xE0, 0},
+		{"SMI", []byte{0xF8, 0x30, 0xFF, 0x10, 0x00}, 0x20, 1},
+		{"SMI borrow", []byte{0xF8, 0x10, 0xFF, 0x30, 0x00}, 0xE0, 0},
+		{"SMBI", []byte{0xF8, 0x10, 0xFF, 0x30, 0xF8, 0x05, 0x7F, 0x01, 0x00}, 0x03, 1},
+		{"SHR", []byte{0xF8, 0x81, 0xF6, 0x00}, 0x40, 1},
+		{"SHL", []byte{0xF8, 0x81, 0xFE, 0x00}, 0x02, 1},
+		{"SHRC", []byte{0xF8, 0xFF, 0xFC, 0x01, 0xF8, 0x02, 0x76, 0x00}, 0x81, 0},
+		{"SHLC", []byte{0xF8, 0xFF, 0xFC, 0x01, 0xF8, 0x80, 0x7E, 0x00}, 0x01, 1},
+		{"ORI ANI XRI", []byte{0xF8, 0x0F, 0xF9, 0xF0, 0xFA, 0x3C, 0xFB, 0x01, 0x00}, 0x3D, 0},
+		{"PHI PLO GHI GLO", []byte{0xF8, 0x12, 0xB5, 0xF8, 0x34, 0xA5, 0x95, 0xFC, 0x00, 0x85, 0x00}, 0x34, 0},
+		{"INC DEC", []byte{0xF8, 0xFF, 0xA5, 0x15, 0x15, 0x25, 0x95, 0x00}, 0x01, 0},
+		{"STR LDN", []byte{0xF8, 0x40, 0xA5, 0xF8, 0x77, 0x55, 0xF8, 0x00, 0x05, 0x00}, 0x77, 0},
+		{"LDA", []byte{0xF8, 0x40, 0xA5, 0xF8, 0x11, 0x55, 0x45, 0x85, 0x00}, 0x41, 0},
+		{"SEX STXD LDXA", []byte{0xF8, 0x41, 0xA5, 0xE5, 0xF8, 0x99, 0x73, 0xF8, 0x00, 0x60, 0x72, 0x00}, 0x99, 0},
+		{"ADD", []byte{0xF8, 0x40, 0xA5, 0xE5, 0xF8, 0x80, 0x55, 0xF4, 0x00}, 0x00, 1},
+		{"BZ taken", []byte{0xF8, 0x00, 0x32, 0x06, 0xF8, 0x11, 0x00}, 0x00, 0},
+		{"BNZ not taken", []byte{0xF8, 0x00, 0x3A, 0x06, 0xF8, 0x11, 0x00}, 0x11, 0},
+		{"BDF", []byte{0xF8, 0x80, 0xFE, 0x33, 0x08, 0xF8, 0x11, 0x00, 0xF8, 0x22, 0x00}, 0x22, 1},
+		{"LBR", []byte{0xC0, 0x00, 0x06, 0xF8, 0x11, 0x00, 0xF8, 0x22, 0x00}, 0x22, 0},
+		{"LSZ", []byte{0xF8, 0x00, 0xCE, 0xF8, 0x11, 0x00}, 0x00, 0},
+		{"LSNZ not taken", []byte{0xF8, 0x00, 0xC6, 0xF8, 0x11, 0x00}, 0x11, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cpu := &CDP1802{X: 2}
+			cpu.R[2] = 0xFF
+			run1802(t, cpu, make([]byte, 0x100), test.code)
+
+			if cpu.D != test.d || cpu.DF != test.df {
+				t.Errorf("D=%02X DF=%d, want D=%02X DF=%d", cpu.D, cpu.DF, test.d, test.df)
+			}
+		})
+	}
+}
+
+func TestCDP1802MarkReturn(t *testing.T) {
+	// MARK saves X and P on the stack and makes X=P, SEP 5 calls the
+	// subroutine, which returns to X=2 and P=0 with RET
+	code := []byte{
+		0xF8, 0x10, 0xA5, // 00: R5 = 0010
+		0x79,             // 03: MARK
+		0xD5,             // 04: SEP 5
+		0xF8, 0x33, 0x00, // 05: D = 33, IDL
+		0, 0, 0, 0, 0, 0, 0, 0,
+		0xE2, 0x12, 0x70, // 10: SEX 2, INC 2, RET
+	}
+	cpu := &CDP1802{X: 2}
+	cpu.R[2] = 0xFF
+	memory := make([]byte, 0x100)
+	run1802(t, cpu, memory, code)
+
+	if cpu.D != 0x33 || cpu.P != 0 || cpu.X != 2 || !cpu.IE {
+		t.Errorf("D=%02X P=%X X=%X IE=%t, want D=33 P=0 X=2 IE=true", cpu.D, cpu.P, cpu.X, cpu.IE)
+	}
+	if cpu.R[2] != 0x100 {
+		t.Errorf("R2=%04X, want 0100", cpu.R[2])
+	}
+	if memory[0xFF] != 0x20 {
+		t.Errorf("MARK saved %02X, want 20", memory[0xFF])
+	}
+}
+
+func TestCDP1802Keypad(t *testing.T) {
+	// OUT 2 latches a key, B3 branches while it is held
+	code := []byte{
+		0xE0, 0x62, 0x05, // 00: SEX 0, OUT 2 of key 5
+		0x36, 0x07, // 03: B3 07
+		0x00,             // 05: IDL
+		0x00,             // 06
+		0xF8, 0x01, 0x00, // 07: D = 1, IDL
+	}
+	var latch byte
+	cpu := &CDP1802{X: 2}
+	cpu.Out = func(port, value byte) {
+		if port == 2 {
+			latch = value
+		}
+	}
+	cpu.EF = func(n byte) bool { return n == 3 && latch == 5 }
+	run1802(t, cpu, make([]byte, 0x100), code)
+
+	if cpu.D != 0x01 {
+		t.Errorf("D=%02X, want 01: B3 was not taken for the latched key", cpu.D)
+	}
+}
+
+// hybridProgram calls the machine code at 0x210, which adds VY to VX of the
+// 0210 instruction (V1 to V2) through the pointers the VIP interpreter leaves
+// in R6 and R7, points I at 0x300 and returns with SEP R4.
+var hybridProgram = []byte{
+	0x62, 0x05, // 200: V2 = 5
+	0x61, 0x07, // 202: V1 = 7
+	0x02, 0x10, // 204: call machine code at 210
+	0x12, 0x06, // 206: loop
+	0, 0, 0, 0, 0, 0, 0, 0,
+	0xE6,       // 210: SEX 6
+	0x07,       // 211: D = V1
+	0xF4,       // 212: D += V2
+	0x56,       // 213: V2 = D
+	0xF8, 0x03, // 214: I = 0300
+	0xBA,
+	0xF8, 0x00,
+	0xAA,
+	0xD4, // 21A: SEP R4
+}
+
+func TestMachineCodeReturn(t *testing.T) {
+	vm, err := LoadROM(hybridProgram, Config{Platform: PlatformChip8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runSteps(t, vm, 3)
+
+	if vm.V[2] != 12 || vm.V[1] != 7 {
+		t.Errorf("V2=%d V1=%d, want 12 7", vm.V[2], vm.V[1])
+	}
+	if vm.PC != 0x206 {
+		t.Errorf("PC=%03X, want 206", vm.PC)
+	}
+	if vm.I != 0x300 {
+		t.Errorf("I=%03X, want 300", vm.I)
+	}
+}
+
+func TestInvalidZeroOpcode(t *testing.T) {
+	tests := []struct {
+		platform Platform
+		program  []byte
+	}{
+		// SCHIP's exit, which isn't machine code
+		{PlatformChip8, []byte{0x00, 0xFD}},
+		{PlatformMegaChip, []byte{0x0A, 0x10}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.platform.String(), func(t *testing.T) {
+			vm, err := LoadROM(test.program, Config{Platform: test.platform})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer func() {
+				r := recover()
+				if !strings.HasPrefix(fmt.Sprint(r), "Invalid opcode") {
+					t.Errorf("got %v, want an invalid opcode", r)
+				}
+			}()
+			vm.Step()
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package chip8
+
+import "image/color"
+
+// CHIP-8X colours are set per zone of 8x1 pixels. BXY0 sets whole 8x4
+// blocks, BXYN sets single rows.
+const (
+	CHIP8X_ZONE_WIDTH  = 8
+	CHIP8X_ZONE_HEIGHT = 4
+
+	CHIP8X_DEFAULT_COLOR = 1
+)
+
+// Foreground colours of the VP-590 colour board, indexed by the colour code.
+var CHIP8X_FOREGROUND = [8]color.RGBA{
+	{R: 0, G: 0, B: 0, A: 255},       // black
+	{R: 255, G: 0, B: 0, A: 255},     // red
+	{R: 0, G: 0, B: 255, A: 255},     // blue
+	{R: 255, G: 0, B: 255, A: 255},   // violet
+	{R: 0, G: 255, B: 0, A: 255},     // green
+	{R: 255, G: 255, B: 0, A: 255},   // yellow
+	{R: 0, G: 255, B: 255, A: 255},   // aqua
+	{R: 255, G: 255, B: 255, A: 255}, // white
+}
+
+// Background colours in the order 02A0 steps through them.
+var CHIP8X_BACKGROUND = [4]color.RGBA{
+	{R: 0, G: 0, B: 128, A: 255}, // dark blue
+	{R: 0, G: 0, B: 0, A: 255},   // black
+	{R: 0, G: 128, B: 0, A: 255}, // green
+	{R: 128, G: 0, B: 0, A: 255}, // red
+}
+
+func newColorMap(width, height int) [][]byte {
+	colorMap := newVideo(width/CHIP8X_ZONE_WIDTH, height)
+	for y := range colorMap {
+		for x := range colorMap[y] {
+			colorMap[y][x] = CHIP8X_DEFAULT_COLOR
+		}
+	}
+
+	return colorMap
+}
+
+// ForegroundColor returns the colour of a lit pixel at the given position.
+// It is only meaningful when the VM has a colour map (CHIP-8X).
+func (vm *VirtualMachine) ForegroundColor(x, y int) color.RGBA {
+	return CHIP8X_FOREGROUND[vm.ColorMap[y][x/CHIP8X_ZONE_WIDTH]&0x7]
+}
+
+// BackgroundColor returns the colour of unlit pixels (CHIP-8X).
+func (vm *VirtualMachine) BackgroundColor() color.RGBA {
+	return CHIP8X_BACKGROUND[vm.Background&0x3]
+}
+
+func (vm *VirtualMachine) stepBackground() {
+	vm.Background = (vm.Background + 1) % byte(len(CHIP8X_BACKGROUND))
+}
+
+func (vm *VirtualMachine) addNibbles(x, y uint) {
+	high := (vm.V[x] + vm.V[y]&0xF0) & 0xF0
+	low := (vm.V[x] + vm.V[y]&0x0F) & 0x0F
+
+	vm.V[x] = high | low
+}
+
+func (vm *VirtualMachine) colorBlocks(x, y uint) {
+	left := int(vm.V[x] & 0xF)
+	right := left + int(vm.V[x]>>4)
+	top := int(vm.V[(x+1)&0xF] & 0xF)
+	bottom := top + int(vm.V[(x+1)&0xF]>>4)
+
+	for row := top * CHIP8X_ZONE_HEIGHT; row < (bottom+1)*CHIP8X_ZONE_HEIGHT; row++ {
+		for column := left; column <= right; column++ {
+			vm.setZoneColor(column, row, vm.V[y])
+		}
+	}
+}
+
+func (vm *VirtualMachine) colorRows(x, y uint, n byte) {
+	column := int(vm.V[x]) / CHIP8X_ZONE_WIDTH
+	top := int(vm.V[(x+1)&0xF])
+
+	for row := top; row < top+int(n); row++ {
+		vm.setZoneColor(column, row, vm.V[y])
+	}
+}
+
+func (vm *VirtualMachine) setZoneColor(column, row int, c byte) {
+	maxY := len(vm.ColorMap)
+	maxX := len(vm.ColorMap[0])
+
+	vm.ColorMap[row%maxY][column%maxX] = c & 0x7
+}
+
+func (vm *VirtualMachine) skipIfPressed2(x uint) {
+	if vm.Keys2[vm.V[x]&0xF] {
+		vm.PC += 2
+	}
+}
+
+func (vm *VirtualMachine) skipIfNotPressed2(x uint) {
+	if !vm.Keys2[vm.V[x]&0xF] {
+		vm.PC += 2
+	}
+}
+
+func (vm *VirtualMachine) outputTone(x uint) {
+	vm.Tone = vm.V[x]
+	vm.HasTone = true
+}
+
+func (vm *VirtualMachine) inputPort(x uint) {
+	// nothing is connected to the input port
+	vm.V[x] = 0
+}
+
+// ToneFrequency returns the pitch of the VP-595 tone generator in Hz.
+func (vm *VirtualMachine) ToneFrequency() float64 {
+	return 27535.0 / (float64(vm.Tone) + 1)
+}
+
+func (vm *VirtualMachine) PressKey2(key uint) {
+	if key < 16 {
+		vm.Keys2[key] = true
+	}
+}
+
+func (vm *VirtualMachine) ReleasedKey2(key uint) {
+	if key < 16 {
+		vm.Keys2[key] = false
+	}
+}
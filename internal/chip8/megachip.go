@@ -0,0 +1,259 @@
+package chip8
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+const (
+	MEGACHIP_WIDTH       = 256
+	MEGACHIP_HEIGHT      = 192
+	MEGACHIP_MEMORY_SIZE = 0x1000000
+)
+
+// Blend modes selected by 080N.
+const (
+	BLEND_NORMAL = iota
+	BLEND_25
+	BLEND_50
+	BLEND_ADD
+	BLEND_MULTIPLY
+)
+
+// Sample is a digitised sound started by 060N. Position is advanced by the
+// audio output while the sample is playing.
+type Sample struct {
+	Rate     float64
+	Data     []byte
+	Loop     bool
+	Position float64
+}
+
+var megaChipBackground = color.RGBA{R: 0, G: 0, B: 0, A: 255}
+
+func (vm *VirtualMachine) resetMegaChip() {
+	vm.MegaChip = false
+	vm.Frame = nil
+	vm.Palette = [256]color.RGBA{}
+	vm.Palette[255] = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	vm.SpriteWidth = 0
+	vm.SpriteHeight = 0
+	vm.Alpha = 255
+	vm.BlendMode = BLEND_NORMAL
+	vm.CollisionColor = 0
+	vm.Sample = nil
+}
+
+func (vm *VirtualMachine) setMegaChip(on bool) {
+	vm.MegaChip = on
+
+	if on {
+		vm.Video = newVideo(MEGACHIP_WIDTH, MEGACHIP_HEIGHT)
+		vm.Frame = image.NewRGBA(image.Rect(0, 0, MEGACHIP_WIDTH, MEGACHIP_HEIGHT))
+		vm.clearFrame()
+	} else {
+		spec := vm.Platform.spec()
+		vm.Video = newVideo(spec.Width, spec.Height)
+		vm.Frame = nil
+	}
+}
+
+func (vm *VirtualMachine) clearFrame() {
+	for y := 0; y < vm.Frame.Bounds().Dy(); y++ {
+		for x := 0; x < vm.Frame.Bounds().Dx(); x++ {
+			vm.Frame.SetRGBA(x, y, megaChipBackground)
+		}
+	}
+}
+
+func (vm *VirtualMachine) loadLongI(b byte) {
+	vm.I = uint(b)<<16 | vm.fetch()
+}
+
+// loadPalette loads colours 1 to b from I, 4 bytes (ARGB) each.
+func (vm *VirtualMachine) loadPalette(b byte) error {
+	if vm.I+uint(b)*4 > uint(len(vm.Memory)) {
+		return fmt.Errorf("Palette of %d colours at %06X is outside the memory", b, vm.I)
+	}
+
+	for i := uint(0); i < uint(b); i++ {
+		p := vm.I + i*4
+
+		vm.Palette[i+1] = color.RGBA{
+			A: vm.Memory[p+0],
+			R: vm.Memory[p+1],
+			G: vm.Memory[p+2],
+			B: vm.Memory[p+3],
+		}
+	}
+
+	return nil
+}
+
+func (vm *VirtualMachine) setSpriteWidth(b byte) {
+	vm.SpriteWidth = int(b)
+}
+
+func (vm *VirtualMachine) setSpriteHeight(b byte) {
+	vm.SpriteHeight = int(b)
+}
+
+func (vm *VirtualMachine) setAlpha(b byte) {
+	vm.Alpha = b
+}
+
+// playSample starts the sample at I. As in the MEGA-CHIP documentation, it
+// starts with a 6 byte header: the rate in Hz in 2 bytes, the length in 3
+// bytes and a reserved byte. The 8-bit unsigned data follows.
+func (vm *VirtualMachine) playSample(n byte) error {
+	p := vm.I
+	if p+6 > uint(len(vm.Memory)) {
+		return fmt.Errorf("Sample header at %06X is outside the memory", p)
+	}
+	rate := uint(vm.Memory[p+0])<<8 | uint(vm.Memory[p+1])
+	length := uint(vm.Memory[p+2])<<16 | uint(vm.Memory[p+3])<<8 | uint(vm.Memory[p+4])
+
+	start := p + 6
+	end := start + length
+	if end > uint(len(vm.Memory)) {
+		return fmt.Errorf("Sample of %d bytes at %06X is outside the memory", length, p)
+	}
+
+	vm.Sample = &Sample{
+		Rate: float64(rate),
+		Data: vm.Memory[start:end],
+		Loop: n == 0,
+	}
+
+	return nil
+}
+
+func (vm *VirtualMachine) stopSample() {
+	vm.Sample = nil
+}
+
+func (vm *VirtualMachine) setBlendMode(n byte) {
+	vm.BlendMode = n
+}
+
+func (vm *VirtualMachine) setCollisionColor(b byte) {
+	vm.CollisionColor = b
+}
+
+// drawIndexedSprite draws a SpriteWidth x SpriteHeight sprite whose bytes
+// are palette indices. Index 0 is transparent and the sprite is clipped at
+// the screen edges. VF is set when a pixel of the sprite is drawn over a
+// pixel of the collision colour; the background (index 0) never collides.
+func (vm *VirtualMachine) drawIndexedSprite(x, y uint) {
+	vm.V[0xF] = 0
+
+	width := vm.SpriteWidth
+	if width == 0 {
+		width = 256
+	}
+	height := vm.SpriteHeight
+	if height == 0 {
+		height = 256
+	}
+
+	maxY := len(vm.Video)
+	maxX := len(vm.Video[0])
+
+	for j := 0; j < height; j++ {
+		py := int(vm.V[y]) + j
+		if py >= maxY {
+			break
+		}
+
+		for i := 0; i < width; i++ {
+			px := int(vm.V[x]) + i
+			if px >= maxX {
+				break
+			}
+
+			c := vm.Memory[(vm.I+uint(j*width+i))%uint(len(vm.Memory))]
+			if c == 0 {
+				continue
+			}
+
+			if below := vm.Video[py][px]; below != 0 && below == vm.CollisionColor {
+				vm.V[0xF] = 1
+			}
+			vm.Video[py][px] = c
+			vm.blendPixel(px, py, vm.Palette[c])
+		}
+	}
+}
+
+func (vm *VirtualMachine) blendPixel(x, y int, src color.RGBA) {
+	dst := vm.Frame.RGBAAt(x, y)
+
+	mix := func(s, d uint8, alpha int) uint8 {
+		return uint8((int(s)*alpha + int(d)*(255-alpha)) / 255)
+	}
+
+	switch vm.BlendMode {
+	case BLEND_25:
+		dst = color.RGBA{R: mix(src.R, dst.R, 64), G: mix(src.G, dst.G, 64), B: mix(src.B, dst.B, 64), A: 255}
+	case BLEND_50:
+		dst = color.RGBA{R: mix(src.R, dst.R, 128), G: mix(src.G, dst.G, 128), B: mix(src.B, dst.B, 128), A: 255}
+	case BLEND_ADD:
+		add := func(s, d uint8) uint8 {
+			if int(s)+int(d) > 255 {
+				return 255
+			}
+			return s + d
+		}
+		dst = color.RGBA{R: add(src.R, dst.R), G: add(src.G, dst.G), B: add(src.B, dst.B), A: 255}
+	case BLEND_MULTIPLY:
+		dst = color.RGBA{
+			R: uint8(int(src.R) * int(dst.R) / 255),
+			G: uint8(int(src.G) * int(dst.G) / 255),
+			B: uint8(int(src.B) * int(dst.B) / 255),
+			A: 255,
+		}
+	default:
+		alpha := int(src.A)
+		dst = color.RGBA{R: mix(src.R, dst.R, alpha), G: mix(src.G, dst.G, alpha), B: mix(src.B, dst.B, alpha), A: 255}
+	}
+
+	vm.Frame.SetRGBA(x, y, dst)
+}
+
+// scroll moves the screen contents by dx, dy pixels and blanks what is
+// scrolled in.
+func (vm *VirtualMachine) scroll(dx, dy int) {
+	maxY := len(vm.Video)
+	maxX := len(vm.Video[0])
+
+	video := newVideo(maxX, maxY)
+	var frame *image.RGBA
+	if vm.Frame != nil {
+		frame = image.NewRGBA(vm.Frame.Bounds())
+	}
+
+	for y := 0; y < maxY; y++ {
+		for x := 0; x < maxX; x++ {
+			fromX := x - dx
+			fromY := y - dy
+
+			inside := fromX >= 0 && fromX < maxX && fromY >= 0 && fromY < maxY
+			if inside {
+				video[y][x] = vm.Video[fromY][fromX]
+			}
+			if frame != nil {
+				if inside {
+					frame.SetRGBA(x, y, vm.Frame.RGBAAt(fromX, fromY))
+				} else {
+					frame.SetRGBA(x, y, megaChipBackground)
+				}
+			}
+		}
+	}
+
+	vm.Video = video
+	if frame != nil {
+		vm.Frame = frame
+	}
+}
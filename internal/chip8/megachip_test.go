@@ -0,0 +1,210 @@
+package chip8
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+)
+
+// megaChipSprite draws a 2x2 sprite of palette indices at 5,3 with two
+// colours loaded from memory, then loops.
+var megaChipSprite = []byte{
+	0x00, 0x11, // 200: MEGA-CHIP mode on
+	0x01, 0x00, 0x02, 0x20, // 202: I = 000220
+	0x02, 0x02, // 206: load colours 1 and 2
+	0x01, 0x00, 0x02, 0x28, // 208: I = 000228
+	0x03, 0x02, // 20C: sprite width 2
+	0x04, 0x02, // 20E: sprite height 2
+	0x60, 0x05, // 210: V0 = 5
+	0x61, 0x03, // 212: V1 = 3
+	0xD0, 0x10, // 214: draw at V0, V1
+	0x12, 0x16, // 216: loop
+	0, 0, 0, 0, 0, 0, 0, 0,
+	0xFF, 0xFF, 0x00, 0x00, // 220: colour 1, ARGB red
+	0xFF, 0x00, 0x00, 0xFF, // 224: colour 2, ARGB blue
+	0x01, 0x00, // 228: sprite, 0 is transparent
+	0x02, 0x01,
+}
+
+func runSteps(t *testing.T, vm *VirtualMachine, steps int) {
+	t.Helper()
+	for i := 0; i < steps; i++ {
+		if err := vm.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMegaChipSprite(t *testing.T) {
+	vm, err := LoadROM(megaChipSprite, Config{Platform: PlatformMegaChip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runSteps(t, vm, 9)
+
+	if len(vm.Video) != MEGACHIP_HEIGHT || len(vm.Video[0]) != MEGACHIP_WIDTH {
+		t.Fatalf("video is %dx%d, want %dx%d", len(vm.Video[0]), len(vm.Video), MEGACHIP_WIDTH, MEGACHIP_HEIGHT)
+	}
+
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	tests := []struct {
+		x, y  int
+		index byte
+		color color.RGBA
+	}{
+		{5, 3, 1, red},
+		{6, 3, 0, megaChipBackground},
+		{5, 4, 2, blue},
+		{6, 4, 1, red},
+		{7, 3, 0, megaChipBackground},
+	}
+	for _, test := range tests {
+		if index := vm.Video[test.y][test.x]; index != test.index {
+			t.Errorf("index at %d,%d is %d, want %d", test.x, test.y, index, test.index)
+		}
+		if c := vm.Frame.RGBAAt(test.x, test.y); c != test.color {
+			t.Errorf("colour at %d,%d is %v, want %v", test.x, test.y, c, test.color)
+		}
+	}
+}
+
+func TestMegaChipSample(t *testing.T) {
+	program := []byte{
+		0x01, 0x00, 0x02, 0x08, // 200: I = 000208
+		0x06, 0x01, // 204: play once
+		0x12, 0x06, // 206: loop
+		0x1F, 0x40, // 208: 8000 Hz
+		0x00, 0x00, 0x03, // 20A: 3 bytes long
+		0x00,             // 20D: reserved
+		0x80, 0xFF, 0x00, // 20E: data
+	}
+	vm, err := LoadROM(program, Config{Platform: PlatformMegaChip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runSteps(t, vm, 2)
+
+	if vm.Sample == nil {
+		t.Fatal("no sample is playing")
+	}
+	if vm.Sample.Rate != 8000 || vm.Sample.Loop || !bytes.Equal(vm.Sample.Data, []byte{0x80, 0xFF, 0x00}) {
+		t.Errorf("sample is %v Hz, loop %v, data % X", vm.Sample.Rate, vm.Sample.Loop, vm.Sample.Data)
+	}
+}
+
+func TestMegaChipState(t *testing.T) {
+	vm, err := LoadROM(megaChipSprite, Config{Platform: PlatformMegaChip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runSteps(t, vm, 9)
+	if len(vm.ROM) != BASE+len(megaChipSprite) {
+		t.Errorf("ROM keeps %d bytes, want %d", len(vm.ROM), BASE+len(megaChipSprite))
+	}
+
+	var state bytes.Buffer
+	if err := vm.SaveState(&state); err != nil {
+		t.Fatal(err)
+	}
+	// the memory is mostly empty and compresses well
+	if state.Len() > 1<<20 {
+		t.Errorf("save state takes %d bytes", state.Len())
+	}
+
+	loaded, err := LoadState(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.Memory, vm.Memory) || loaded.Frame.RGBAAt(5, 4) != vm.Frame.RGBAAt(5, 4) {
+		t.Error("loaded state differs from the saved one")
+	}
+}
+
+func TestMegaChipCollision(t *testing.T) {
+	vm, err := LoadROM(megaChipSprite, Config{Platform: PlatformMegaChip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runSteps(t, vm, 9)
+	if vm.V[0xF] != 0 {
+		t.Error("drawing on the empty screen collided")
+	}
+
+	// redraw at 5,3 over the colours 1 and 2 of the first sprite, and over
+	// a pixel of colour 3 where the sprite is transparent
+	vm.Video[3][6] = 3
+	tests := []struct {
+		collision byte
+		vf        byte
+	}{
+		{0, 0},
+		{1, 1},
+		{2, 1},
+		{3, 0},
+	}
+	for _, test := range tests {
+		vm.CollisionColor = test.collision
+		vm.PC = 0x214
+		runSteps(t, vm, 1)
+
+		if vm.V[0xF] != test.vf {
+			t.Errorf("VF is %d with collision colour %d, want %d", vm.V[0xF], test.collision, test.vf)
+		}
+	}
+}
+
+func TestMegaChipOutsideMemory(t *testing.T) {
+	tests := []struct {
+		name        string
+		instruction []byte
+		i           uint
+	}{
+		{"palette", []byte{0x02, 0x02}, MEGACHIP_MEMORY_SIZE - 7},
+		{"sample header", []byte{0x06, 0x01}, MEGACHIP_MEMORY_SIZE - 5},
+		{"sample data", []byte{0x06, 0x01}, 0x208},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			program := append(test.instruction, 0x12, 0x02, // 202: loop
+				0x00, 0x00, 0x00, 0x00,
+				0x1F, 0x40, // 208: 8000 Hz
+				0xFF, 0xFF, 0xFF, // 20A: longer than the memory
+				0x00,
+			)
+			vm, err := LoadROM(program, Config{Platform: PlatformMegaChip})
+			if err != nil {
+				t.Fatal(err)
+			}
+			vm.I = test.i
+
+			if err := vm.Step(); err == nil {
+				t.Error("reading past the end of the memory was no error")
+			}
+			if vm.Sample != nil {
+				t.Error("a sample outside the memory is playing")
+			}
+		})
+	}
+}
+
+func TestMegaChipReset(t *testing.T) {
+	vm, err := LoadROM(megaChipSprite, Config{Platform: PlatformMegaChip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := &vm.Memory[0]
+	vm.Memory[MEGACHIP_MEMORY_SIZE-1] = 0xAA
+
+	vm.Reset()
+	if &vm.Memory[0] != memory {
+		t.Error("Reset allocated new memory")
+	}
+	if vm.Memory[MEGACHIP_MEMORY_SIZE-1] != 0 {
+		t.Error("Reset kept the memory past the program")
+	}
+	if !bytes.Equal(vm.Memory[BASE:BASE+len(megaChipSprite)], megaChipSprite) {
+		t.Error("Reset lost the program")
+	}
+}
@@ -0,0 +1,162 @@
+package chip8
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Platform identifies the interpreter variant a program was written for.
+type Platform int
+
+const (
+	// PlatformAuto detects the platform from the program and, when loading
+	// from a file, its extension. It falls back to PlatformChip8.
+	PlatformAuto Platform = iota
+	PlatformChip8
+	PlatformETI660
+	PlatformHiRes
+	PlatformChip8X
+	PlatformMegaChip
+)
+
+// HIRES_SIGNATURE is the first instruction of every program written for
+// the two-page hi-res CHIP-8 interpreter.
+const HIRES_SIGNATURE = 0x1260
+
+type platformSpec struct {
+	Name       string
+	Base       uint
+	Entry      uint
+	Width      int
+	Height     int
+	MemorySize uint
+	// MachineCode is set for the platforms of 1802 computers, whose
+	// programs can call machine code subroutines with 0NNN
+	MachineCode bool
+}
+
+var platformSpecs = map[Platform]platformSpec{
+	PlatformChip8:  {Name: "chip8", Base: BASE, Width: 64, Height: 32, MachineCode: true},
+	PlatformETI660: {Name: "eti660", Base: ETI_BASE, Width: 64, Height: 48, MachineCode: true},
+	PlatformHiRes:  {Name: "hires", Base: BASE, Entry: 0x2C0, Width: 64, Height: 64, MachineCode: true},
+	PlatformChip8X: {Name: "chip8x", Base: CHIP8X_BASE, Width: 64, Height: 32, MachineCode: true},
+	PlatformMegaChip: {
+		Name: "megachip", Base: BASE, Width: 64, Height: 32,
+		MemorySize: MEGACHIP_MEMORY_SIZE,
+	},
+}
+
+var platformExtensions = map[string]Platform{
+	".ch8": PlatformChip8,
+	".c8":  PlatformChip8,
+	".eti": PlatformETI660,
+	".c8x": PlatformChip8X,
+	".mc8": PlatformMegaChip,
+}
+
+// unsupportedExtensions are the files of Octo that hold programs in another
+// form than a binary ROM.
+var unsupportedExtensions = map[string]string{
+	".8o":  "Octo source (.8o)",
+	".gif": "Octo cartridge (.gif)",
+}
+
+// checkExtension fails for the files of Octo that aren't binary ROMs.
+func checkExtension(filePath string) error {
+	if format, ok := unsupportedExtensions[strings.ToLower(filepath.Ext(filePath))]; ok {
+		return fmt.Errorf("%s files can't be run, only binary ROMs", format)
+	}
+
+	return nil
+}
+
+// PlatformDatabase knows the platforms of programs, like the ROM database.
+// It returns PlatformAuto for the programs it doesn't know.
+type PlatformDatabase interface {
+	PlatformOf(program []byte) Platform
+}
+
+// Config selects how a program is placed in memory and run.
+// Zero values mean "use the platform default".
+type Config struct {
+	Platform Platform
+	// Database is asked for the platform of a file before its extension
+	// by LoadFromFile. It may be nil.
+	Database PlatformDatabase
+	Base     uint
+	Entry    uint
+	Timing   Timing
+	Quirks   Quirks
+	// Seed starts the random number generator, 0 picks a new seed on
+	// every reset
+	Seed int64
+}
+
+func (spec platformSpec) memorySize() uint {
+	if spec.MemorySize == 0 {
+		return MEMORY_SIZE
+	}
+
+	return spec.MemorySize
+}
+
+func (p Platform) spec() platformSpec {
+	if spec, ok := platformSpecs[p]; ok {
+		return spec
+	}
+
+	return platformSpecs[PlatformChip8]
+}
+
+func (p Platform) String() string {
+	if p == PlatformAuto {
+		return "auto"
+	}
+
+	return p.spec().Name
+}
+
+// ParsePlatform converts a platform name as printed by Platform.String.
+func ParsePlatform(name string) (Platform, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || name == "auto" {
+		return PlatformAuto, nil
+	}
+
+	for platform, spec := range platformSpecs {
+		if spec.Name == name {
+			return platform, nil
+		}
+	}
+
+	return PlatformAuto, fmt.Errorf("Unknown platform: %s", name)
+}
+
+// DetectPlatform recognizes platforms that can be told apart by the program
+// itself. It returns PlatformAuto when nothing is recognized.
+func DetectPlatform(program []byte) Platform {
+	if len(program) >= 2 && uint(program[0])<<8|uint(program[1]) == HIRES_SIGNATURE {
+		return PlatformHiRes
+	}
+
+	return PlatformAuto
+}
+
+// PlatformFromExtension guesses the platform of a ROM file by its extension.
+func PlatformFromExtension(filePath string) Platform {
+	if platform, ok := platformExtensions[strings.ToLower(filepath.Ext(filePath))]; ok {
+		return platform
+	}
+
+	return PlatformAuto
+}
+
+func newVideo(width, height int) [][]byte {
+	video := make([][]byte, height)
+	for y := range video {
+		video[y] = make([]byte, width)
+	}
+
+	return video
+}
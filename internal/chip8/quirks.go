@@ -0,0 +1,66 @@
+package chip8
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Quirks select between the behaviours that CHIP-8 interpreters disagree
+// on. The zero value is the behaviour of this emulator before quirks could
+// be chosen, which most modern programs expect.
+type Quirks struct {
+	// ShiftVY makes 8XY6 and 8XYE shift VY into VX instead of shifting VX
+	ShiftVY bool
+	// LoadStoreI makes FX55 and FX65 leave I past the last register
+	LoadStoreI bool
+	// JumpVX makes BNNN jump to NNN + VX, where X is the top nibble of NNN
+	JumpVX bool
+	// VFReset makes 8XY1, 8XY2 and 8XY3 clear VF
+	VFReset bool
+	// Clip clips sprites at the edges of the display instead of wrapping
+	// them around
+	Clip bool
+}
+
+type quirksProfile struct {
+	Name   string
+	Quirks Quirks
+}
+
+// QuirksProfiles are the quirks of well-known interpreters.
+var QuirksProfiles = []quirksProfile{
+	{Name: "modern"},
+	{Name: "vip", Quirks: Quirks{ShiftVY: true, LoadStoreI: true, VFReset: true, Clip: true}},
+	{Name: "schip", Quirks: Quirks{JumpVX: true, Clip: true}},
+	{Name: "xochip", Quirks: Quirks{ShiftVY: true, LoadStoreI: true}},
+}
+
+// String returns the name of the profile with these quirks, or "custom".
+func (q Quirks) String() string {
+	for _, profile := range QuirksProfiles {
+		if profile.Quirks == q {
+			return profile.Name
+		}
+	}
+
+	return "custom"
+}
+
+// ParseQuirks returns the quirks of a profile named as printed by
+// Quirks.String.
+func ParseQuirks(name string) (Quirks, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return Quirks{}, nil
+	}
+
+	names := make([]string, len(QuirksProfiles))
+	for i, profile := range QuirksProfiles {
+		if profile.Name == name {
+			return profile.Quirks, nil
+		}
+		names[i] = profile.Name
+	}
+
+	return Quirks{}, fmt.Errorf("Unknown quirks profile: %s (one of %s)", name, strings.Join(names, ", "))
+}
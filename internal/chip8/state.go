@@ -0,0 +1,175 @@
+package chip8
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+)
+
+// STATE_VERSION changes whenever saved states of older versions can't be
+// loaded anymore.
+const STATE_VERSION = 2
+
+// state is what a save state stores: the VM with the parts that gob can't
+// see or can't restore by itself.
+type state struct {
+	Version  int
+	VM       VirtualMachine
+	KeyLatch byte
+	// Waiting is the register FX0A waits for a key for, or -1
+	Waiting int
+}
+
+// SaveState writes everything needed to continue running the VM later.
+// States are compressed, as the memory of MEGA-CHIP is mostly empty.
+func (vm *VirtualMachine) SaveState(w io.Writer) error {
+	s := state{
+		Version:  STATE_VERSION,
+		VM:       *vm,
+		KeyLatch: vm.keyLatch,
+		Waiting:  -1,
+	}
+	s.VM.W = nil
+	for i := range vm.V {
+		if vm.W == &vm.V[i] {
+			s.Waiting = i
+		}
+	}
+
+	zw := gzip.NewWriter(w)
+	if err := gob.NewEncoder(zw).Encode(&s); err != nil {
+		zw.Close()
+		return err
+	}
+
+	return zw.Close()
+}
+
+// LoadState reads a VM written by SaveState.
+func LoadState(r io.Reader) (*VirtualMachine, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid save state: %w", err)
+	}
+	defer zr.Close()
+
+	var s state
+	if err := gob.NewDecoder(zr).Decode(&s); err != nil {
+		return nil, fmt.Errorf("Invalid save state: %w", err)
+	}
+	if s.Version != STATE_VERSION {
+		return nil, fmt.Errorf("Save state version %d is not supported", s.Version)
+	}
+
+	vm := &s.VM
+	if err := vm.validateState(); err != nil {
+		return nil, fmt.Errorf("Invalid save state: %w", err)
+	}
+
+	vm.keyLatch = s.KeyLatch
+	if s.Waiting >= 0 && s.Waiting < len(vm.V) {
+		vm.W = &vm.V[s.Waiting]
+	}
+
+	return vm, nil
+}
+
+// validateState checks that a loaded VM can run without reading outside
+// its memory, stack or display.
+func (vm *VirtualMachine) validateState() error {
+	spec := vm.Platform.spec()
+	memorySize := uint(len(vm.Memory))
+	if len(vm.Memory) != int(spec.memorySize()) || vm.Base+uint(vm.Size) != uint(len(vm.ROM)) {
+		return errors.New("memory does not match the platform")
+	}
+
+	if vm.PC > memorySize-2 {
+		return fmt.Errorf("PC %04X is outside the memory", vm.PC)
+	}
+	if vm.I >= memorySize {
+		return fmt.Errorf("I %04X is outside the memory", vm.I)
+	}
+	if vm.SP > uint(len(vm.Stack)) {
+		return fmt.Errorf("SP %d is outside the stack", vm.SP)
+	}
+	for _, address := range vm.Stack[:vm.SP] {
+		if address > memorySize-2 {
+			return fmt.Errorf("return address %04X is outside the memory", address)
+		}
+	}
+
+	if vm.MegaChip && vm.Platform != PlatformMegaChip {
+		return errors.New("MEGA-CHIP mode is on for another platform")
+	}
+	width, height := spec.Width, spec.Height
+	if vm.MegaChip {
+		width, height = MEGACHIP_WIDTH, MEGACHIP_HEIGHT
+	}
+	if !hasSize(vm.Video, width, height) {
+		return fmt.Errorf("display is not %dx%d", width, height)
+	}
+	if vm.Platform == PlatformChip8X {
+		if !hasSize(vm.ColorMap, width/CHIP8X_ZONE_WIDTH, height) {
+			return errors.New("colour map does not match the display")
+		}
+	} else if vm.ColorMap != nil {
+		return errors.New("colour map on a platform without colours")
+	}
+
+	if vm.MegaChip {
+		frame := vm.Frame
+		if frame == nil || frame.Rect != image.Rect(0, 0, width, height) ||
+			frame.Stride != width*4 || len(frame.Pix) != frame.Stride*height {
+			return fmt.Errorf("MEGA-CHIP frame is not %dx%d", width, height)
+		}
+	} else if vm.Frame != nil {
+		return errors.New("MEGA-CHIP frame outside of MEGA-CHIP mode")
+	}
+	if vm.SpriteWidth < 0 || vm.SpriteWidth > 0xFF || vm.SpriteHeight < 0 || vm.SpriteHeight > 0xFF {
+		return fmt.Errorf("sprite size %dx%d is outside 0 to 255", vm.SpriteWidth, vm.SpriteHeight)
+	}
+
+	return nil
+}
+
+// hasSize tells whether rows are height rows of width bytes.
+func hasSize(rows [][]byte, width, height int) bool {
+	if len(rows) != height {
+		return false
+	}
+	for _, row := range rows {
+		if len(row) != width {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (vm *VirtualMachine) SaveStateFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := vm.SaveState(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func LoadStateFile(path string) (*VirtualMachine, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return LoadState(file)
+}
@@ -0,0 +1,79 @@
+package chip8
+
+import (
+	"bytes"
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestLoadStateInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		platform Platform
+		megaChip bool
+		change   func(vm *VirtualMachine)
+	}{
+		{"valid", PlatformChip8, false, func(vm *VirtualMachine) {}},
+		{"valid CHIP-8X", PlatformChip8X, false, func(vm *VirtualMachine) {}},
+		{"valid MEGA-CHIP", PlatformMegaChip, true, func(vm *VirtualMachine) {}},
+		{"PC", PlatformChip8, false, func(vm *VirtualMachine) { vm.PC = 0xFFF }},
+		{"short memory", PlatformChip8, false, func(vm *VirtualMachine) { vm.Memory = vm.Memory[:0x800] }},
+		{"return address", PlatformChip8, false, func(vm *VirtualMachine) {
+			vm.SP = 1
+			vm.Stack[0] = 0x1000
+		}},
+		{"no display", PlatformChip8, false, func(vm *VirtualMachine) { vm.Video = nil }},
+		{"256 wide display", PlatformChip8, false, func(vm *VirtualMachine) {
+			vm.Video = newVideo(MEGACHIP_WIDTH, 32)
+		}},
+		{"short display", PlatformETI660, false, func(vm *VirtualMachine) { vm.Video = newVideo(64, 32) }},
+		{"ragged display", PlatformChip8, false, func(vm *VirtualMachine) { vm.Video[5] = vm.Video[5][:63] }},
+		{"colour map without colours", PlatformChip8, false, func(vm *VirtualMachine) {
+			vm.ColorMap = newColorMap(64, 32)
+		}},
+		{"no colour map", PlatformChip8X, false, func(vm *VirtualMachine) { vm.ColorMap = nil }},
+		{"colour map of another size", PlatformChip8X, false, func(vm *VirtualMachine) {
+			vm.ColorMap = newColorMap(128, 32)
+		}},
+		{"MEGA-CHIP mode of CHIP-8", PlatformChip8, false, func(vm *VirtualMachine) { vm.MegaChip = true }},
+		{"low resolution MEGA-CHIP mode", PlatformMegaChip, true, func(vm *VirtualMachine) {
+			vm.Video = newVideo(64, 32)
+		}},
+		{"no MEGA-CHIP frame", PlatformMegaChip, true, func(vm *VirtualMachine) { vm.Frame = nil }},
+		{"small MEGA-CHIP frame", PlatformMegaChip, true, func(vm *VirtualMachine) {
+			vm.Frame = image.NewRGBA(image.Rect(0, 0, 64, 32))
+		}},
+		{"MEGA-CHIP frame outside MEGA-CHIP mode", PlatformMegaChip, false, func(vm *VirtualMachine) {
+			vm.Frame = image.NewRGBA(image.Rect(0, 0, MEGACHIP_WIDTH, MEGACHIP_HEIGHT))
+		}},
+		{"sprite size", PlatformMegaChip, true, func(vm *VirtualMachine) { vm.SpriteWidth = 1 << 20 }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vm, err := LoadROM([]byte{0x12, 0x00}, Config{Platform: test.platform})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.megaChip {
+				vm.setMegaChip(true)
+			}
+			test.change(vm)
+
+			var state bytes.Buffer
+			if err := vm.SaveState(&state); err != nil {
+				t.Fatal(err)
+			}
+			_, err = LoadState(&state)
+
+			valid := strings.HasPrefix(test.name, "valid")
+			if valid && err != nil {
+				t.Errorf("valid state failed to load: %s", err)
+			}
+			if !valid && err == nil {
+				t.Error("invalid state was loaded")
+			}
+		})
+	}
+}
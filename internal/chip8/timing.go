@@ -0,0 +1,159 @@
+package chip8
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Timing selects how instructions are paced.
+type Timing int
+
+const (
+	// TimingFixed runs one instruction per clock tick of the host and counts
+	// the timers down on host timers.
+	TimingFixed Timing = iota
+	// TimingVIP charges each instruction what it cost on the COSMAC VIP in
+	// machine cycles, and derives the 60 Hz interrupt from that count.
+	TimingVIP
+)
+
+// VIP machine cycles. The CPU ran at 1.7609 MHz with 8 clocks per machine
+// cycle, and the display interrupt with its DMA took a large part of every
+// frame. The instruction costs are approximations from analyses of the VIP
+// interpreter.
+const (
+	VIP_FRAME_CYCLES     = 3668
+	VIP_INTERRUPT_CYCLES = 1070
+
+	VIP_FETCH_CYCLES = 40
+	VIP_SKIP_CYCLES  = 4
+)
+
+func (t Timing) String() string {
+	switch t {
+	case TimingVIP:
+		return "vip"
+	default:
+		return "fixed"
+	}
+}
+
+// ParseTiming converts a timing name as printed by Timing.String.
+func ParseTiming(name string) (Timing, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "fixed":
+		return TimingFixed, nil
+	case "vip":
+		return TimingVIP, nil
+	}
+
+	return TimingFixed, fmt.Errorf("Unknown timing: %s", name)
+}
+
+// RunFrame runs instructions until the next 60 Hz interrupt. It is meant
+// for TimingVIP, where the interrupt also counts the timers down.
+func (vm *VirtualMachine) RunFrame() error {
+	frame := vm.Cycles / VIP_FRAME_CYCLES
+
+	for vm.Cycles/VIP_FRAME_CYCLES == frame {
+		if err := vm.Step(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// spendCycles advances the clock and runs the interrupts that fall into the
+// spent cycles.
+func (vm *VirtualMachine) spendCycles(cycles int64) {
+	before := vm.Cycles
+	vm.Cycles += cycles
+
+	if vm.Timing != TimingVIP {
+		return
+	}
+
+	for frame := before / VIP_FRAME_CYCLES; frame < vm.Cycles/VIP_FRAME_CYCLES; frame++ {
+		vm.interrupt()
+	}
+}
+
+// waitForInterrupt idles until the next 60 Hz interrupt, like the VIP
+// interpreter did before drawing and while waiting for a key.
+func (vm *VirtualMachine) waitForInterrupt() {
+	vm.spendCycles(VIP_FRAME_CYCLES - vm.Cycles%VIP_FRAME_CYCLES)
+}
+
+func (vm *VirtualMachine) interrupt() {
+	if vm.DT > 0 {
+		vm.DT--
+	}
+	if vm.ST > 0 {
+		vm.ST--
+	}
+
+	// the display DMA steals these cycles from the interpreter
+	vm.Cycles += VIP_INTERRUPT_CYCLES
+}
+
+// vipCycles returns the machine cycles an instruction took on the VIP.
+// skipped tells whether a skip instruction skipped, machineCycles how
+// long a 0NNN subroutine ran.
+func (vm *VirtualMachine) vipCycles(instruction uint, skipped bool, machineCycles int64) int64 {
+	x := instruction >> 8 & 0xF
+	n := instruction & 0xF
+
+	var cycles int64
+	switch {
+	case instruction == 0x00E0:
+		cycles = 24 + 3054
+	case instruction == 0x00EE:
+		cycles = 10
+	case instruction&0xF000 == 0x0000:
+		cycles = 10 + machineCycles
+	case instruction&0xF000 == 0x1000:
+		cycles = 12
+	case instruction&0xF000 == 0x2000:
+		cycles = 26
+	case instruction&0xF000 == 0x3000, instruction&0xF000 == 0x4000:
+		cycles = 10
+	case instruction&0xF000 == 0x5000, instruction&0xF000 == 0x9000:
+		cycles = 14
+	case instruction&0xF000 == 0x6000:
+		cycles = 6
+	case instruction&0xF000 == 0x7000:
+		cycles = 10
+	case instruction&0xF000 == 0x8000:
+		cycles = 44
+	case instruction&0xF000 == 0xA000:
+		cycles = 12
+	case instruction&0xF000 == 0xB000:
+		cycles = 22
+	case instruction&0xF000 == 0xC000:
+		cycles = 36
+	case instruction&0xF000 == 0xD000:
+		// sprites that aren't byte aligned have to be shifted into two bytes
+		perRow := int64(46)
+		if vm.V[x]%8 != 0 {
+			perRow = 68
+		}
+		cycles = 26 + perRow*int64(n)
+	case instruction&0xF000 == 0xE000:
+		cycles = 14
+	case instruction&0xF0FF == 0xF033:
+		cycles = 80 + 16*int64(vm.Memory[vm.I%uint(len(vm.Memory))]+vm.Memory[(vm.I+1)%uint(len(vm.Memory))]+vm.Memory[(vm.I+2)%uint(len(vm.Memory))])
+	case instruction&0xF0FF == 0xF055, instruction&0xF0FF == 0xF065:
+		cycles = 14 + 14*int64(x+1)
+	case instruction&0xF0FF == 0xF01E, instruction&0xF0FF == 0xF029:
+		cycles = 16
+	default:
+		cycles = 10
+	}
+
+	if skipped {
+		cycles += VIP_SKIP_CYCLES
+	}
+
+	return VIP_FETCH_CYCLES + cycles
+}
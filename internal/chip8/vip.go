@@ -0,0 +1,111 @@
+package chip8
+
+import "fmt"
+
+// Work areas of the COSMAC VIP interpreter, which machine code subroutines
+// expect to find the CHIP-8 state in.
+const (
+	VIP_STACK     = 0xECF
+	VIP_REGISTERS = 0xEF0
+	VIP_DISPLAY   = 0xF00
+
+	// VIP_MAX_MACHINE_CYCLES stops a subroutine that never returns.
+	VIP_MAX_MACHINE_CYCLES = 1000000
+)
+
+// canCallMachineCode tells whether 0NNN calls a machine code subroutine at
+// address. Only programs of 1802 computers do, and only in their own
+// memory: below the load address is the interpreter, which isn't machine
+// code here. Other 0NNN instructions are invalid, like the SCHIP
+// instructions this emulator doesn't know.
+func (vm *VirtualMachine) canCallMachineCode(address uint) bool {
+	return vm.Platform.spec().MachineCode && address >= vm.Base
+}
+
+// callMachineCode runs the 1802 subroutine at address the way the VIP
+// interpreter did: with P=3, X=2 and the CHIP-8 state in registers and
+// memory, until the subroutine returns with SEP R4.
+func (vm *VirtualMachine) callMachineCode(address uint, x, y uint) {
+	vm.exportVIPState()
+
+	cpu := &vm.CPU
+	cpu.R[2] = VIP_STACK
+	cpu.R[3] = uint16(address)
+	cpu.R[5] = uint16(vm.PC)
+	cpu.R[6] = uint16(VIP_REGISTERS + x)
+	cpu.R[7] = uint16(VIP_REGISTERS + y)
+	cpu.R[8] = uint16(vm.DT)<<8 | uint16(vm.ST)
+	cpu.R[0xA] = uint16(vm.I)
+	cpu.R[0xB] = VIP_DISPLAY
+	cpu.P = 3
+	cpu.X = 2
+	cpu.Idle = false
+
+	cpu.EF = func(n byte) bool {
+		// EF3 is the hex keypad, latched with OUT 2
+		return n == 3 && vm.Keys[vm.keyLatch&0xF]
+	}
+	cpu.Out = func(port byte, value byte) {
+		if port == 2 {
+			vm.keyLatch = value
+		}
+	}
+
+	start := cpu.Cycles
+	for cpu.P != 4 {
+		if cpu.Idle || cpu.Cycles-start > VIP_MAX_MACHINE_CYCLES {
+			panic(fmt.Sprintf("Machine code at %03X did not return!", address))
+		}
+		cpu.Step(vm.Memory)
+	}
+
+	vm.importVIPState()
+}
+
+func (vm *VirtualMachine) hasVIPDisplay() bool {
+	return !vm.MegaChip && len(vm.Video) == 32 && len(vm.Video[0]) == 64 &&
+		len(vm.Memory) >= VIP_DISPLAY+0x100
+}
+
+// exportVIPState copies V and the display into the VIP work areas.
+func (vm *VirtualMachine) exportVIPState() {
+	copy(vm.Memory[VIP_REGISTERS:], vm.V[:])
+
+	if !vm.hasVIPDisplay() {
+		return
+	}
+
+	for y := range vm.Video {
+		for x := 0; x < len(vm.Video[y]); x += 8 {
+			var b byte
+			for i := 0; i < 8; i++ {
+				b = b<<1 | vm.Video[y][x+i]&0x1
+			}
+			vm.Memory[VIP_DISPLAY+y*8+x/8] = b
+		}
+	}
+}
+
+// importVIPState reads back what a subroutine changed.
+func (vm *VirtualMachine) importVIPState() {
+	cpu := &vm.CPU
+
+	copy(vm.V[:], vm.Memory[VIP_REGISTERS:])
+	vm.PC = uint(cpu.R[5])
+	vm.I = uint(cpu.R[0xA])
+	vm.DT = byte(cpu.R[8] >> 8)
+	vm.ST = byte(cpu.R[8])
+
+	if !vm.hasVIPDisplay() {
+		return
+	}
+
+	for y := range vm.Video {
+		for x := 0; x < len(vm.Video[y]); x += 8 {
+			b := vm.Memory[VIP_DISPLAY+y*8+x/8]
+			for i := 0; i < 8; i++ {
+				vm.Video[y][x+i] = b >> (7 - i) & 0x1
+			}
+		}
+	}
+}
@@ -3,19 +3,50 @@ package chip8
 import (
 	"errors"
 	"fmt"
+	"image"
+	"image/color"
 	"io/ioutil"
-	"math/rand"
 	"time"
 	"unicode"
 )
 
-const BASE = 0x200
+const (
+	BASE        = 0x200
+	ETI_BASE    = 0x600
+	CHIP8X_BASE = 0x300
 
-type VirtualMachine struct {
-	ROM [0x1000]byte
+	MEMORY_SIZE = 0x1000
+)
 
-	Memory [0x1000]byte
-	Video  [32][64]byte
+type VirtualMachine struct {
+	// ROM is the memory as loaded, up to the end of the program. Reset
+	// copies it into Memory, which has the full size of the platform.
+	ROM []byte
+
+	Memory []byte
+	Video  [][]byte
+
+	// CHIP-8X colour zones and background colour
+	ColorMap   [][]byte
+	Background byte
+
+	// MEGA-CHIP mode: Video holds palette indices and Frame the blended
+	// colours actually shown
+	MegaChip       bool
+	Frame          *image.RGBA
+	Palette        [256]color.RGBA
+	SpriteWidth    int
+	SpriteHeight   int
+	Alpha          byte
+	BlendMode      byte
+	CollisionColor byte
+	Sample         *Sample
+
+	// CPU runs the 1802 machine code subroutines of hybrid programs
+	CPU      CDP1802
+	keyLatch byte
+
+	Platform Platform
 
 	Stack [16]uint
 
@@ -25,6 +56,8 @@ type VirtualMachine struct {
 
 	Base uint
 
+	Entry uint
+
 	Size int
 
 	I uint
@@ -41,26 +74,81 @@ type VirtualMachine struct {
 
 	Cycles int64
 
+	Timing Timing
+
+	Quirks Quirks
+
 	Speed int64
 
+	// Seed and Random are the seed and state of the random number
+	// generator
+	Seed   int64
+	Random uint64
+
 	W *byte
 
 	Keys [16]bool
 
-	Pitch int
+	// CHIP-8X second keypad and tone generator, which sets the pitch of
+	// the buzzer once FXF8 has set a tone
+	Keys2   [16]bool
+	Tone    byte
+	HasTone bool
+
+	// Planes are the XO-CHIP bit planes that drawing and clearing change,
+	// selected by FN01. Video holds the planes of every pixel as its bits.
+	Planes byte
+
+	// XO-CHIP audio pattern, played instead of the buzzer once loaded
+	Pattern    [PATTERN_SIZE]byte
+	HasPattern bool
+	Pitch      int
 }
 
-func LoadROM(program []byte, eti bool) (*VirtualMachine, error) {
-	base := BASE
+func LoadROM(program []byte, config Config) (*VirtualMachine, error) {
+	platform := config.Platform
+	if platform == PlatformAuto {
+		platform = DetectPlatform(program)
+	}
+	if platform == PlatformAuto {
+		platform = PlatformChip8
+	}
+	spec := platform.spec()
 
-	if len(program) > 0x1000-base {
+	base := config.Base
+	if base == 0 {
+		base = spec.Base
+	}
+	memorySize := spec.memorySize()
+	if base >= memorySize {
+		return nil, fmt.Errorf("Invalid load address: %04X", base)
+	}
+
+	entry := config.Entry
+	if entry == 0 && base == spec.Base {
+		entry = spec.Entry
+	}
+	if entry == 0 {
+		entry = base
+	}
+	if entry >= memorySize-1 {
+		return nil, fmt.Errorf("Invalid entry address: %04X", entry)
+	}
+
+	if len(program) > int(memorySize-base) {
 		return nil, errors.New("Program too large to fit int memory!")
 	}
 
 	vm := &VirtualMachine{
-		Size:  len(program),
-		Base:  uint(BASE),
-		Speed: 500,
+		Platform: platform,
+		ROM:      make([]byte, base+uint(len(program))),
+		Size:     len(program),
+		Base:     base,
+		Entry:    entry,
+		Timing:   config.Timing,
+		Quirks:   config.Quirks,
+		Speed:    500,
+		Seed:     config.Seed,
 	}
 
 	copy(vm.ROM[:base], EmulatorROM[:])
@@ -71,7 +159,11 @@ func LoadROM(program []byte, eti bool) (*VirtualMachine, error) {
 	return vm, nil
 }
 
-func LoadFromFile(filePath string) (*VirtualMachine, error) {
+func LoadFromFile(filePath string, config Config) (*VirtualMachine, error) {
+	if err := checkExtension(filePath); err != nil {
+		return nil, err
+	}
+
 	file, err := ioutil.ReadFile(filePath)
 	if err != nil {
 		return nil, err
@@ -83,15 +175,66 @@ func LoadFromFile(filePath string) (*VirtualMachine, error) {
 		}
 	}
 
-	return LoadROM(file, false)
+	if config.Platform == PlatformAuto && config.Database != nil {
+		config.Platform = config.Database.PlatformOf(file)
+	}
+	if config.Platform == PlatformAuto {
+		config.Platform = DetectPlatform(file)
+	}
+	if config.Platform == PlatformAuto {
+		config.Platform = PlatformFromExtension(filePath)
+	}
+
+	return LoadROM(file, config)
+}
+
+// Program returns the bytes that were loaded at the base address.
+func (vm *VirtualMachine) Program() []byte {
+	return vm.ROM[vm.Base : vm.Base+uint(vm.Size)]
+}
+
+// Config returns the configuration needed to load the same program again.
+func (vm *VirtualMachine) Config() Config {
+	return Config{
+		Platform: vm.Platform,
+		Base:     vm.Base,
+		Entry:    vm.Entry,
+		Timing:   vm.Timing,
+		Quirks:   vm.Quirks,
+		Seed:     vm.Seed,
+	}
 }
 
 func (vm *VirtualMachine) Reset() {
-	copy(vm.Memory[:], vm.ROM[:])
-	vm.Video = [len(vm.Video)][len(vm.Video[0])]byte{}
+	spec := vm.Platform.spec()
+
+	// the memory is reused, MEGA-CHIP's has 16 MB
+	if len(vm.Memory) != int(spec.memorySize()) {
+		vm.Memory = make([]byte, spec.memorySize())
+	}
+	rest := vm.Memory[copy(vm.Memory, vm.ROM):]
+	for i := range rest {
+		rest[i] = 0
+	}
+	vm.Video = newVideo(spec.Width, spec.Height)
+
+	vm.ColorMap = nil
+	if vm.Platform == PlatformChip8X {
+		vm.ColorMap = newColorMap(spec.Width, spec.Height)
+	}
+	vm.Background = 0
+
+	vm.resetMegaChip()
+
+	vm.CPU = CDP1802{}
+	vm.keyLatch = 0
 
 	vm.Keys = [16]bool{}
-	vm.PC = vm.Base
+	vm.Keys2 = [16]bool{}
+	vm.Tone = 0
+	vm.HasTone = false
+	vm.Planes = 1
+	vm.PC = vm.Entry
 	vm.SP = 0
 
 	vm.I = 0
@@ -105,17 +248,37 @@ func (vm *VirtualMachine) Reset() {
 	vm.Clock = time.Now().UnixNano()
 	vm.Cycles = 0
 
+	vm.Random = uint64(vm.Seed)
+	if vm.Seed == 0 {
+		vm.Random = uint64(vm.Clock)
+	}
+	if vm.Random == 0 {
+		// xorshift never leaves 0
+		vm.Random = 1
+	}
+
 	vm.W = nil
 
-	vm.Pitch = 8
+	vm.Pattern = [PATTERN_SIZE]byte{}
+	vm.HasPattern = false
+	vm.Pitch = PITCH_DEFAULT
 }
 
 func (vm *VirtualMachine) Step() error {
 	if vm.W != nil {
+		if vm.Timing == TimingVIP {
+			vm.waitForInterrupt()
+		}
 		return nil
 	}
 
 	instruction := vm.fetch()
+	pc := vm.PC
+	machineCycles := vm.CPU.Cycles
+
+	if vm.Timing == TimingVIP && instruction&0xF000 == 0xD000 {
+		vm.waitForInterrupt()
+	}
 
 	a := instruction & 0xFFF
 
@@ -128,8 +291,48 @@ func (vm *VirtualMachine) Step() error {
 	switch {
 	case instruction == 0x00E0:
 		vm.cls()
+	case instruction == 0x0230 && vm.Platform == PlatformHiRes:
+		vm.cls()
+	case instruction == 0x02A0 && vm.Platform == PlatformChip8X:
+		vm.stepBackground()
 	case instruction == 0x00EE:
 		vm.ret()
+	case instruction == 0x0010 && vm.Platform == PlatformMegaChip:
+		vm.setMegaChip(false)
+	case instruction == 0x0011 && vm.Platform == PlatformMegaChip:
+		vm.setMegaChip(true)
+	case instruction&0xFFF0 == 0x00B0 && vm.Platform == PlatformMegaChip:
+		vm.scroll(0, -int(n))
+	case instruction&0xFFF0 == 0x00C0 && vm.Platform == PlatformMegaChip:
+		vm.scroll(0, int(n))
+	case instruction == 0x00FB && vm.Platform == PlatformMegaChip:
+		vm.scroll(4, 0)
+	case instruction == 0x00FC && vm.Platform == PlatformMegaChip:
+		vm.scroll(-4, 0)
+	case instruction&0xFF00 == 0x0100 && vm.Platform == PlatformMegaChip:
+		vm.loadLongI(b)
+	case instruction&0xFF00 == 0x0200 && vm.Platform == PlatformMegaChip:
+		if err := vm.loadPalette(b); err != nil {
+			return err
+		}
+	case instruction&0xFF00 == 0x0300 && vm.Platform == PlatformMegaChip:
+		vm.setSpriteWidth(b)
+	case instruction&0xFF00 == 0x0400 && vm.Platform == PlatformMegaChip:
+		vm.setSpriteHeight(b)
+	case instruction&0xFF00 == 0x0500 && vm.Platform == PlatformMegaChip:
+		vm.setAlpha(b)
+	case instruction&0xFFF0 == 0x0600 && vm.Platform == PlatformMegaChip:
+		if err := vm.playSample(n); err != nil {
+			return err
+		}
+	case instruction == 0x0700 && vm.Platform == PlatformMegaChip:
+		vm.stopSample()
+	case instruction&0xFFF0 == 0x0800 && vm.Platform == PlatformMegaChip:
+		vm.setBlendMode(n)
+	case instruction&0xFF00 == 0x0900 && vm.Platform == PlatformMegaChip:
+		vm.setCollisionColor(b)
+	case instruction&0xF000 == 0x0000 && vm.canCallMachineCode(a):
+		vm.callMachineCode(a, x, y)
 	case instruction&0xF000 == 0x1000:
 		vm.jump(a)
 	case instruction&0xF000 == 0x2000:
@@ -138,6 +341,8 @@ func (vm *VirtualMachine) Step() error {
 		vm.skipIf(x, b)
 	case instruction&0xF000 == 0x4000:
 		vm.skipIfNot(x, b)
+	case instruction&0xF00F == 0x5001 && vm.Platform == PlatformChip8X:
+		vm.addNibbles(x, y)
 	case instruction&0xF000 == 0x5000:
 		vm.skipIfXY(x, y)
 	case instruction&0xF000 == 0x6000:
@@ -157,17 +362,21 @@ func (vm *VirtualMachine) Step() error {
 	case instruction&0xF00F == 0x8005:
 		vm.subXY(x, y)
 	case instruction&0xF00F == 0x8006:
-		vm.shr(x)
+		vm.shr(x, y)
 	case instruction&0xF00F == 0x8007:
 		vm.subnXY(x, y)
 	case instruction&0xF00F == 0x800E:
-		vm.shl(x)
+		vm.shl(x, y)
 	case instruction&0xF00F == 0x9000:
 		vm.skipIfNotXY(x, y)
 	case instruction&0xF000 == 0xA000:
 		vm.loadI(a)
+	case instruction&0xF00F == 0xB000 && vm.Platform == PlatformChip8X:
+		vm.colorBlocks(x, y)
+	case instruction&0xF000 == 0xB000 && vm.Platform == PlatformChip8X:
+		vm.colorRows(x, y, n)
 	case instruction&0xF000 == 0xB000:
-		vm.jumpV0(a)
+		vm.jumpV0(a, x)
 	case instruction&0xF000 == 0xC000:
 		vm.random(x, b)
 	case instruction&0xF000 == 0xD000:
@@ -176,6 +385,14 @@ func (vm *VirtualMachine) Step() error {
 		vm.skipIfPressed(x)
 	case instruction&0xF0FF == 0xE0A1:
 		vm.skipIfNotPressed(x)
+	case instruction&0xF0FF == 0xE0F2 && vm.Platform == PlatformChip8X:
+		vm.skipIfPressed2(x)
+	case instruction&0xF0FF == 0xE0F5 && vm.Platform == PlatformChip8X:
+		vm.skipIfNotPressed2(x)
+	case instruction&0xF0FF == 0xF001:
+		vm.selectPlanes(x)
+	case instruction == 0xF002:
+		vm.loadPattern()
 	case instruction&0xF0FF == 0xF007:
 		vm.loadXDT(x)
 	case instruction&0xF0FF == 0xF00A:
@@ -190,16 +407,32 @@ func (vm *VirtualMachine) Step() error {
 		vm.loadF(x)
 	case instruction&0xF0FF == 0xF033:
 		vm.bcd(x, y)
+	case instruction&0xF0FF == 0xF03A:
+		vm.loadPitch(x)
 	case instruction&0xF0FF == 0xF055:
 		vm.saveRegs(x)
 	case instruction&0xF0FF == 0xF065:
 		vm.loadRegs(x)
+	case instruction&0xF0FF == 0xF0F8 && vm.Platform == PlatformChip8X:
+		vm.outputTone(x)
+	case instruction&0xF0FF == 0xF0FB && vm.Platform == PlatformChip8X:
+		vm.inputPort(x)
 	default:
 		// return fmt.Errorf("Invalid opcode: %04X", instruction)
 		panic(fmt.Sprintf("Invalid opcode: %04X", instruction))
 	}
 
-	vm.Cycles += 1
+	if vm.Timing == TimingVIP {
+		skipped := false
+		switch instruction & 0xF000 {
+		case 0x3000, 0x4000, 0x5000, 0x9000, 0xE000:
+			skipped = vm.PC != pc
+		}
+
+		vm.spendCycles(vm.vipCycles(instruction, skipped, vm.CPU.Cycles-machineCycles))
+	} else {
+		vm.spendCycles(1)
+	}
 
 	return nil
 }
@@ -213,11 +446,20 @@ func (vm *VirtualMachine) fetch() uint {
 }
 
 func (vm *VirtualMachine) cls() {
+	// only the selected planes are cleared, MEGA-CHIP clears everything
+	keep := ^vm.Planes
+	if vm.MegaChip {
+		keep = 0
+	}
 	for i := 0; i < len(vm.Video); i++ {
 		for j := 0; j < len(vm.Video[i]); j++ {
-			vm.Video[i][j] = 0x0
+			vm.Video[i][j] &= keep
 		}
 	}
+
+	if vm.Frame != nil {
+		vm.clearFrame()
+	}
 }
 
 func (vm *VirtualMachine) ret() {
@@ -276,14 +518,23 @@ func (vm *VirtualMachine) loadXY(x, y uint) {
 
 func (vm *VirtualMachine) or(x, y uint) {
 	vm.V[x] |= vm.V[y]
+	vm.resetVF()
 }
 
 func (vm *VirtualMachine) and(x, y uint) {
 	vm.V[x] &= vm.V[y]
+	vm.resetVF()
 }
 
 func (vm *VirtualMachine) xor(x, y uint) {
 	vm.V[x] ^= vm.V[y]
+	vm.resetVF()
+}
+
+func (vm *VirtualMachine) resetVF() {
+	if vm.Quirks.VFReset {
+		vm.V[0xF] = 0
+	}
 }
 
 func (vm *VirtualMachine) addXY(x, y uint) {
@@ -306,7 +557,11 @@ func (vm *VirtualMachine) subXY(x, y uint) {
 	vm.V[x] -= vm.V[y]
 }
 
-func (vm *VirtualMachine) shr(x uint) {
+func (vm *VirtualMachine) shr(x, y uint) {
+	if vm.Quirks.ShiftVY {
+		vm.V[x] = vm.V[y]
+	}
+
 	vm.V[0xF] = vm.V[x] & 0x1
 
 	vm.V[x] >>= 1
@@ -322,7 +577,11 @@ func (vm *VirtualMachine) subnXY(x, y uint) {
 	vm.V[x] = vm.V[y] - vm.V[x]
 }
 
-func (vm *VirtualMachine) shl(x uint) {
+func (vm *VirtualMachine) shl(x, y uint) {
+	if vm.Quirks.ShiftVY {
+		vm.V[x] = vm.V[y]
+	}
+
 	vm.V[0xF] = vm.V[x] >> 7
 	vm.V[x] <<= 1
 }
@@ -337,26 +596,56 @@ func (vm *VirtualMachine) loadI(address uint) {
 	vm.I = address
 }
 
-func (vm *VirtualMachine) jumpV0(address uint) {
+func (vm *VirtualMachine) jumpV0(address, x uint) {
+	if vm.Quirks.JumpVX {
+		vm.PC = address + uint(vm.V[x])
+		return
+	}
+
 	vm.PC = address + uint(vm.V[0x0])
 }
 
 func (vm *VirtualMachine) random(x uint, b byte) {
-	vm.V[x] = byte(rand.Intn(256) & int(b))
+	// xorshift64*, so that the state can be seeded and saved
+	vm.Random ^= vm.Random >> 12
+	vm.Random ^= vm.Random << 25
+	vm.Random ^= vm.Random >> 27
+
+	vm.V[x] = byte((vm.Random*2685821657736338717)>>56) & b
 }
 
 func (vm *VirtualMachine) drawSprite(x, y uint, n byte) {
+	if vm.MegaChip {
+		vm.drawIndexedSprite(x, y)
+		return
+	}
+
 	vm.V[0xF] = 0
 
+	// every selected plane takes the next n bytes of the sprite
+	address := vm.I
+	for plane := byte(1); plane <= PLANES; plane <<= 1 {
+		if vm.Planes&plane != 0 {
+			vm.drawPlane(address, x, y, n, plane)
+			address += uint(n)
+		}
+	}
+}
+
+func (vm *VirtualMachine) drawPlane(address, x, y uint, n, plane byte) {
 	var i, j byte
 	maxY := byte(len(vm.Video))
 	maxX := byte(len(vm.Video[0]))
 
 	for j = 0; j < n; j++ {
-		pixel := vm.Memory[vm.I+uint(j)]
+		pixel := vm.Memory[(address+uint(j))%uint(len(vm.Memory))]
 
 		for i = 0; i < 8; i++ {
 			if (pixel & (0x80 >> i)) != 0 {
+				if vm.Quirks.Clip && (int(vm.V[y]%maxY)+int(j) >= int(maxY) || int(vm.V[x]%maxX)+int(i) >= int(maxX)) {
+					continue
+				}
+
 				wrapY := vm.V[y] + j
 				if wrapY >= maxY {
 					wrapY %= byte(maxY)
@@ -366,10 +655,10 @@ func (vm *VirtualMachine) drawSprite(x, y uint, n byte) {
 					wrapX %= byte(maxX)
 				}
 
-				if vm.Video[wrapY][wrapX] == 1 {
+				if vm.Video[wrapY][wrapX]&plane != 0 {
 					vm.V[0xF] = 1
 				}
-				vm.Video[wrapY][wrapX] ^= 1
+				vm.Video[wrapY][wrapX] ^= plane
 			}
 		}
 	}
@@ -406,7 +695,7 @@ func (vm *VirtualMachine) loadSTX(x uint) {
 func (vm *VirtualMachine) addIX(x uint) {
 	vm.I += uint(vm.V[x])
 
-	if vm.I >= 0x1000 {
+	if vm.I >= uint(len(vm.Memory)) {
 		vm.V[0xF] = 1
 	} else {
 		vm.V[0xF] = 0
@@ -442,20 +731,28 @@ func (vm *VirtualMachine) bcd(x, y uint) {
 
 func (vm *VirtualMachine) saveRegs(x uint) {
 	for i := uint(0); i <= x; i++ {
-		if vm.I+i < 0x1000 {
+		if vm.I+i < uint(len(vm.Memory)) {
 			vm.Memory[vm.I+i] = vm.V[i]
 		}
 	}
+
+	if vm.Quirks.LoadStoreI {
+		vm.I += x + 1
+	}
 }
 
 func (vm *VirtualMachine) loadRegs(x uint) {
 	for i := uint(0); i <= x; i++ {
-		if vm.I+i < 0x1000 {
+		if vm.I+i < uint(len(vm.Memory)) {
 			vm.V[i] = vm.Memory[vm.I+i]
 		} else {
 			vm.V[i] = 0
 		}
 	}
+
+	if vm.Quirks.LoadStoreI {
+		vm.I += x + 1
+	}
 }
 
 func (vm *VirtualMachine) PressKey(key uint) {
@@ -0,0 +1,37 @@
+package chip8
+
+import "math"
+
+// XO-CHIP audio: the buzzer plays a 128 sample 1-bit pattern, looped, at a
+// rate set by the pitch register.
+const (
+	PATTERN_SIZE = 16
+
+	PITCH_DEFAULT   = 64
+	PITCH_BASE_RATE = 4000.0
+)
+
+// PLANES is the mask of the XO-CHIP bit planes.
+const PLANES = 0x3
+
+// selectPlanes selects the planes that FN01 names by the bits of n.
+func (vm *VirtualMachine) selectPlanes(n uint) {
+	vm.Planes = byte(n) & PLANES
+}
+
+func (vm *VirtualMachine) loadPattern() {
+	for i := range vm.Pattern {
+		vm.Pattern[i] = vm.Memory[(vm.I+uint(i))%uint(len(vm.Memory))]
+	}
+
+	vm.HasPattern = true
+}
+
+func (vm *VirtualMachine) loadPitch(x uint) {
+	vm.Pitch = int(vm.V[x])
+}
+
+// PatternRate returns how many pattern samples are played per second.
+func (vm *VirtualMachine) PatternRate() float64 {
+	return PITCH_BASE_RATE * math.Pow(2, float64(vm.Pitch-PITCH_DEFAULT)/48)
+}
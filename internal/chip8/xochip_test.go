@@ -0,0 +1,39 @@
+package chip8
+
+import "testing"
+
+func TestPlanes(t *testing.T) {
+	program := []byte{
+		0xA2, 0x10, // 200: I = 210
+		0xF2, 0x01, // 202: select plane 2
+		0xD0, 0x01, // 204: draw 1 row at 0,0
+		0xF3, 0x01, // 206: select both planes
+		0xD0, 0x01, // 208: draw 1 row for each plane at 0,0
+		0xF1, 0x01, // 20A: select plane 1
+		0x00, 0xE0, // 20C: clear plane 1
+		0x12, 0x0E, // 20E: loop
+		0xC0, // 210: plane 2 of the first draw, plane 1 of the second
+		0x60, // 211: plane 2 of the second draw
+	}
+	vm, err := LoadROM(program, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		steps int
+		row   []byte
+		vf    byte
+	}{
+		{3, []byte{2, 2, 0, 0}, 0},
+		// plane 1 gets C0 and plane 2 toggles with 60
+		{2, []byte{3, 1, 2, 0}, 1},
+		{3, []byte{2, 0, 2, 0}, 1},
+	}
+	for i, step := range steps {
+		runSteps(t, vm, step.steps)
+		if got := vm.Video[0][:4]; string(got) != string(step.row) || vm.V[0xF] != step.vf {
+			t.Errorf("after step %d the row is %v with VF %d, want %v with VF %d", i, got, vm.V[0xF], step.row, step.vf)
+		}
+	}
+}
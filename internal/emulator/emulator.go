@@ -0,0 +1,539 @@
+package emulator
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"sync/atomic"
+	"time"
+
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/audio"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/chip8"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/palette"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/recorder"
+)
+
+var VIDEO_HZ = time.Second / 60
+
+// the clock rate is how many instructions the VM runs per second with
+// fixed timing
+const (
+	CLOCK_RATE     = 500
+	MIN_CLOCK_RATE = 60
+	MAX_CLOCK_RATE = 3000000
+)
+
+// cycles are the instructions per 60 Hz frame, the clock rate as the GUI
+// shows it
+const (
+	MIN_CYCLES = MIN_CLOCK_RATE / 60
+	MAX_CYCLES = MAX_CLOCK_RATE / 60
+)
+
+// COMMAND_QUEUE is how many commands can wait before sending one blocks.
+const COMMAND_QUEUE = 256
+
+const (
+	SPEED_DEFAULT = 100
+	MIN_SPEED     = 10
+	MAX_SPEED     = 1000
+	// TURBO_SPEED is the speed while turbo is held
+	TURBO_SPEED = MAX_SPEED
+)
+
+// Snapshot is the state of the emulator at one moment. Snapshots are never
+// changed after they are published, so they can be read from any
+// goroutine. Only Frame is drawn over again, two snapshots later.
+type Snapshot struct {
+	// Frame is the display at the VM's resolution, in the palette's colours.
+	// Its image is reused, so only OnFrame may read it.
+	Frame *image.RGBA
+	// Background is the colour of dark pixels in Frame
+	Background color.RGBA
+	Video      [][]byte
+
+	V     [16]byte
+	Stack [16]uint
+	SP    uint
+	PC    uint
+	I     uint
+	DT    byte
+	ST    byte
+
+	Platform chip8.Platform
+	Base     uint
+	Timing   chip8.Timing
+	Config   chip8.Config
+	// Program is shared with the VM, which never changes it
+	Program []byte
+
+	Running bool
+	// Speed is the emulation speed in percent, without turbo
+	Speed     int
+	Turbo     bool
+	ClockRate int
+	// Frames counts the 60 Hz frames the VM has run since it was loaded
+	Frames uint64
+}
+
+// Diff tells whether the display and the registers of two snapshots
+// differ.
+func (s *Snapshot) Diff(other *Snapshot) (video bool, registers bool) {
+	registers = s.V != other.V || s.Stack != other.Stack || s.SP != other.SP ||
+		s.PC != other.PC || s.I != other.I || s.DT != other.DT || s.ST != other.ST
+
+	if len(s.Video) != len(other.Video) {
+		return true, registers
+	}
+	for y := range s.Video {
+		if !bytes.Equal(s.Video[y], other.Video[y]) {
+			return true, registers
+		}
+	}
+
+	return false, registers
+}
+
+// Emulator runs a VM on its own goroutine. Other goroutines control it
+// with commands, which are queued and run between frames, and read
+// the snapshots it publishes.
+type Emulator struct {
+	commands chan func()
+	steps    chan lockstepFrame
+	quit     chan struct{}
+	done     chan struct{}
+
+	snapshot atomic.Value
+	palette  atomic.Value
+	// frameBuffers are the images of the snapshots' frames, used in turn
+	frameBuffers [2]*image.RGBA
+	frameBuffer  int
+
+	// owned by the emulator goroutine
+	vm        *chip8.VirtualMachine
+	audio     *audio.Audio
+	running   bool
+	speed     int
+	turbo     bool
+	clockRate int
+	frames    uint64
+	lockstep  bool
+	ticker    *time.Ticker
+
+	// script is played, up to played; recorded is nil unless recording
+	script   []Input
+	played   int
+	recorded []Input
+
+	// OnFrame is called on the emulator goroutine with the snapshot of
+	// every 60 Hz frame. It has to be set before Start.
+	OnFrame func(snapshot *Snapshot)
+	// OnError is called on the emulator goroutine when the VM fails, after
+	// the emulator has paused it. It has to be set before Start.
+	OnError func(err error)
+}
+
+func New(vm *chip8.VirtualMachine, a *audio.Audio) *Emulator {
+	e := &Emulator{
+		commands:  make(chan func(), COMMAND_QUEUE),
+		steps:     make(chan lockstepFrame),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+		vm:        vm,
+		audio:     a,
+		running:   true,
+		speed:     SPEED_DEFAULT,
+		clockRate: CLOCK_RATE,
+	}
+	e.palette.Store(palette.Default())
+	e.publish()
+
+	return e
+}
+
+// Start runs the emulator goroutine.
+func (e *Emulator) Start() {
+	go e.run()
+}
+
+// Quit stops the emulator goroutine and waits until it has ended.
+func (e *Emulator) Quit() {
+	close(e.quit)
+	<-e.done
+}
+
+// Snapshot returns the latest published state.
+func (e *Emulator) Snapshot() *Snapshot {
+	return e.snapshot.Load().(*Snapshot)
+}
+
+func (e *Emulator) Palette() palette.Palette {
+	return e.palette.Load().(palette.Palette)
+}
+
+// SetPalette changes the colours of the snapshots' frames.
+func (e *Emulator) SetPalette(p palette.Palette) {
+	e.palette.Store(p)
+}
+
+// Do queues f to run on the emulator goroutine with the VM. f must not
+// keep the VM.
+func (e *Emulator) Do(f func(vm *chip8.VirtualMachine)) {
+	e.commands <- func() {
+		f(e.vm)
+	}
+}
+
+// Load replaces the VM and starts running it. The caller must not use vm
+// afterwards.
+func (e *Emulator) Load(vm *chip8.VirtualMachine) {
+	e.commands <- func() {
+		e.vm = vm
+		e.running = true
+		e.restart()
+		e.script = nil
+	}
+}
+
+// Reset loads the program of the VM again and starts running it. The VM
+// and its memory are kept.
+func (e *Emulator) Reset() {
+	e.commands <- func() {
+		e.vm.Reset()
+		e.running = true
+		e.restart()
+	}
+}
+
+func (e *Emulator) Pause() {
+	e.commands <- func() {
+		e.running = false
+		e.silence()
+	}
+}
+
+func (e *Emulator) Resume() {
+	e.commands <- func() {
+		e.running = true
+	}
+}
+
+func (e *Emulator) SetTiming(timing chip8.Timing) {
+	e.commands <- func() {
+		e.vm.Timing = timing
+	}
+}
+
+// SetSpeed changes the emulation speed to percent of the normal speed.
+func (e *Emulator) SetSpeed(percent int) {
+	if percent < MIN_SPEED {
+		percent = MIN_SPEED
+	} else if percent > MAX_SPEED {
+		percent = MAX_SPEED
+	}
+
+	e.commands <- func() {
+		e.speed = percent
+		e.retime()
+	}
+}
+
+// SetTurbo runs the VM at TURBO_SPEED while on is set, whatever the speed.
+func (e *Emulator) SetTurbo(on bool) {
+	e.commands <- func() {
+		e.turbo = on
+		e.retime()
+	}
+}
+
+// Advance runs exactly one frame while the VM is paused: a 60 Hz frame of
+// instructions and a tick of the timers.
+func (e *Emulator) Advance() {
+	e.commands <- func() {
+		if e.running || e.lockstep {
+			return
+		}
+
+		e.runFrame()
+		e.silence()
+		if e.OnFrame != nil {
+			e.OnFrame(e.publish())
+		}
+	}
+}
+
+// SetClockRate changes how many instructions the VM runs per second with
+// fixed timing.
+func (e *Emulator) SetClockRate(rate int) {
+	e.commands <- func() {
+		e.clockRate = rate
+	}
+}
+
+// SetAudio moves the sound of the VM to a.
+func (e *Emulator) SetAudio(a *audio.Audio) {
+	e.commands <- func() {
+		e.silence()
+		e.audio = a
+	}
+}
+
+// Input presses or releases a key before the next frame.
+func (e *Emulator) Input(in Input) {
+	e.commands <- func() {
+		e.input(in)
+	}
+}
+
+// Play gives the VM the inputs at their frames, counted from when the VM
+// was loaded or reset. Loading another VM stops playing.
+func (e *Emulator) Play(inputs []Input) {
+	e.commands <- func() {
+		e.script = inputs
+		e.played = 0
+	}
+}
+
+// RecordInputs starts recording the inputs the VM is given.
+func (e *Emulator) RecordInputs() {
+	e.commands <- func() {
+		e.recorded = []Input{}
+	}
+}
+
+// StopRecordingInputs stops recording inputs and returns them.
+func (e *Emulator) StopRecordingInputs() []Input {
+	result := make(chan []Input)
+	e.commands <- func() {
+		result <- e.recorded
+		e.recorded = nil
+	}
+
+	return <-result
+}
+
+// Poke writes value to memory at address.
+func (e *Emulator) Poke(address uint, value byte) {
+	e.commands <- func() {
+		e.vm.Memory[address%uint(len(e.vm.Memory))] = value
+	}
+}
+
+func (e *Emulator) run() {
+	defer close(e.done)
+
+	// the VM runs a frame of instructions at a time, as often as the speed
+	// asks, while the display is shown at 60 Hz
+	e.ticker = time.NewTicker(VIDEO_HZ)
+	e.retime()
+	video := time.NewTicker(VIDEO_HZ)
+	defer e.ticker.Stop()
+	defer video.Stop()
+	defer e.silence()
+
+	for {
+		select {
+		case <-e.quit:
+			return
+		case command := <-e.commands:
+			command()
+			e.publish()
+		case step := <-e.steps:
+			e.step(step)
+		case <-e.ticker.C:
+			if e.running && !e.lockstep {
+				e.runFrame()
+			}
+		case <-video.C:
+			if e.lockstep {
+				break
+			}
+			snapshot := e.publish()
+			if e.OnFrame != nil {
+				e.OnFrame(snapshot)
+			}
+		}
+	}
+}
+
+// retime sets the frame ticker to the speed.
+func (e *Emulator) retime() {
+	speed := e.speed
+	if e.turbo {
+		speed = TURBO_SPEED
+	}
+	if e.ticker != nil {
+		e.ticker.Reset(VIDEO_HZ * SPEED_DEFAULT / time.Duration(speed))
+	}
+}
+
+// restart counts the frames again from a new start of the VM.
+func (e *Emulator) restart() {
+	e.frames = 0
+	e.played = 0
+	if e.recorded != nil {
+		e.recorded = e.recorded[:0]
+	}
+}
+
+func (e *Emulator) input(in Input) {
+	in.Frame = e.frames
+	in.Apply(e.vm)
+
+	if e.recorded != nil {
+		e.recorded = append(e.recorded, in)
+	}
+}
+
+// runFrame runs a frame of the VM. A VM that fails, by an invalid
+// instruction or its stack, is paused instead of ending the program.
+func (e *Emulator) runFrame() {
+	defer func() {
+		if r := recover(); r != nil {
+			e.fail(fmt.Errorf("%v", r))
+		}
+	}()
+
+	for e.played < len(e.script) && e.script[e.played].Frame <= e.frames {
+		e.input(e.script[e.played])
+		e.played++
+	}
+
+	if err := RunFrame(e.vm, e.frames, e.clockRate); err != nil {
+		e.fail(err)
+		return
+	}
+	e.sound()
+	e.frames++
+}
+
+func (e *Emulator) fail(err error) {
+	e.running = false
+	e.silence()
+	if e.OnError != nil {
+		e.OnError(err)
+	}
+}
+
+// step runs one frame for the lockstep, after the input queued for it.
+func (e *Emulator) step(step lockstepFrame) {
+	for _, in := range step.inputs {
+		e.input(in)
+	}
+	if e.running {
+		e.runFrame()
+	}
+
+	snapshot := e.publish()
+	if e.OnFrame != nil {
+		e.OnFrame(snapshot)
+	}
+	step.done <- struct{}{}
+}
+
+func (e *Emulator) sound() {
+	Sound(e.vm, e.audio)
+}
+
+// Sound hands the sound of the frame vm just ran to a, and counts the
+// sound timer down with fixed timing.
+func Sound(vm *chip8.VirtualMachine, a *audio.Audio) {
+	// a MEGA-CHIP sample takes over the buzzer while it plays
+	if sample := vm.Sample; sample != nil {
+		var playing bool
+		sample.Position, playing = a.OutSample(sample.Data, sample.Rate, sample.Position, sample.Loop)
+		if !playing {
+			vm.Sample = nil
+		}
+	}
+	if vm.HasPattern {
+		a.SetPattern(vm.Pattern, vm.PatternRate())
+	} else {
+		a.ClearPattern()
+	}
+	if vm.HasTone {
+		a.SetTone(vm.ToneFrequency())
+	} else {
+		a.ClearTone()
+	}
+	a.SetBuzzer(vm.Sample == nil && vm.ST > 0)
+	if vm.Timing == chip8.TimingFixed && vm.ST > 0 {
+		vm.ST--
+	}
+}
+
+func (e *Emulator) silence() {
+	e.audio.SetBuzzer(false)
+}
+
+func (e *Emulator) publish() *Snapshot {
+	vm := e.vm
+	colors := e.Palette()
+
+	// the frame of the previous snapshot stays untouched while OnFrame
+	// may still read it
+	e.frameBuffer = 1 - e.frameBuffer
+	frame := recorder.Capture(e.frameBuffers[e.frameBuffer], vm, colors)
+	e.frameBuffers[e.frameBuffer] = frame
+
+	snapshot := &Snapshot{
+		Frame:      frame,
+		Background: colors.Colors[palette.BACKGROUND],
+		Video:      make([][]byte, len(vm.Video)),
+		V:          vm.V,
+		Stack:      vm.Stack,
+		SP:         vm.SP,
+		PC:         vm.PC,
+		I:          vm.I,
+		DT:         vm.DT,
+		ST:         vm.ST,
+		Platform:   vm.Platform,
+		Base:       vm.Base,
+		Timing:     vm.Timing,
+		Config:     vm.Config(),
+		Program:    vm.Program(),
+		Running:    e.running,
+		Speed:      e.speed,
+		Turbo:      e.turbo,
+		ClockRate:  e.clockRate,
+		Frames:     e.frames,
+	}
+	if vm.ColorMap != nil {
+		snapshot.Background = vm.BackgroundColor()
+	}
+	for y, row := range vm.Video {
+		snapshot.Video[y] = append([]byte(nil), row...)
+	}
+
+	e.snapshot.Store(snapshot)
+	return snapshot
+}
+
+// RunFrame runs the frame-th 60 Hz frame of vm: with fixed timing the
+// instructions of the frame at rate instructions per second and a tick of
+// the delay timer, with VIP timing a whole VIP frame. The sound timer is
+// left to the caller.
+func RunFrame(vm *chip8.VirtualMachine, frame uint64, rate int) error {
+	if vm.Timing == chip8.TimingVIP {
+		// the VM counts its timers down itself at the end of the frame
+		return vm.RunFrame()
+	}
+
+	for i := stepsBefore(frame, rate); i < stepsBefore(frame+1, rate); i++ {
+		if err := vm.Step(); err != nil {
+			return err
+		}
+	}
+	if vm.DT > 0 {
+		vm.DT--
+	}
+
+	return nil
+}
+
+// stepsBefore is how many instructions run before the frame-th frame at
+// rate instructions per second.
+func stepsBefore(frame uint64, rate int) int64 {
+	return int64(frame) * int64(rate) / int64(time.Second/VIDEO_HZ)
+}
@@ -0,0 +1,36 @@
+package emulator
+
+import (
+	"testing"
+
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/chip8"
+)
+
+func TestPublishReusesFrames(t *testing.T) {
+	vm, err := chip8.LoadROM(beep, chip8.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := New(vm, nil)
+
+	first := e.publish()
+	second := e.publish()
+	third := e.publish()
+	if first.Frame == second.Frame {
+		t.Error("the next snapshot draws over the frame of the last one")
+	}
+	if first.Frame != third.Frame {
+		t.Error("every other snapshot has a new frame")
+	}
+
+	allocs := testing.AllocsPerRun(10, func() {
+		before := e.Snapshot().Frame
+		if e.publish().Frame == before {
+			t.Error("the frame of the last snapshot was drawn over")
+		}
+	})
+	// the snapshot and its video rows, but no frame
+	if want := float64(2 + len(vm.Video)); allocs > want {
+		t.Errorf("publish allocates %.0f times, want at most %.0f", allocs, want)
+	}
+}
@@ -0,0 +1,112 @@
+package emulator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/chip8"
+)
+
+// Input is a key of a keypad going down or up before a frame. Keypad 2 is
+// the second keypad of CHIP-8X.
+type Input struct {
+	Frame   uint64
+	Keypad  int
+	Key     uint
+	Pressed bool
+}
+
+func (in Input) Apply(vm *chip8.VirtualMachine) {
+	switch {
+	case in.Keypad == 2 && in.Pressed:
+		vm.PressKey2(in.Key)
+	case in.Keypad == 2:
+		vm.ReleasedKey2(in.Key)
+	case in.Pressed:
+		vm.PressKey(in.Key)
+	default:
+		vm.ReleasedKey(in.Key)
+	}
+}
+
+func (in Input) String() string {
+	state := "up"
+	if in.Pressed {
+		state = "down"
+	}
+
+	return fmt.Sprintf("%d %d %X %s", in.Frame, in.Keypad, in.Key, state)
+}
+
+// ReadInputs reads inputs written by WriteInputs: one per line as
+// "frame keypad key down|up", in the order of their frames. Empty lines
+// and lines starting with # are skipped.
+func ReadInputs(r io.Reader) ([]Input, error) {
+	var inputs []Input
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		var in Input
+		var state string
+		if _, err := fmt.Sscanf(text, "%d %d %X %s", &in.Frame, &in.Keypad, &in.Key, &state); err != nil {
+			return nil, fmt.Errorf("Invalid input on line %d: %q", line, text)
+		}
+		switch {
+		case in.Keypad != 1 && in.Keypad != 2:
+			return nil, fmt.Errorf("Invalid keypad on line %d: %d, it must be 1 or 2", line, in.Keypad)
+		case in.Key > 0xF:
+			return nil, fmt.Errorf("Invalid key on line %d: %X, it must be 0 to F", line, in.Key)
+		case state != "down" && state != "up":
+			return nil, fmt.Errorf("Invalid key state on line %d: %q, it must be down or up", line, state)
+		case len(inputs) > 0 && in.Frame < inputs[len(inputs)-1].Frame:
+			return nil, fmt.Errorf("Input on line %d is at frame %d, before the input above it", line, in.Frame)
+		}
+		in.Pressed = state == "down"
+
+		inputs = append(inputs, in)
+	}
+
+	return inputs, scanner.Err()
+}
+
+func WriteInputs(w io.Writer, inputs []Input) error {
+	buffered := bufio.NewWriter(w)
+	fmt.Fprintln(buffered, "# frame keypad key down|up")
+	for _, in := range inputs {
+		fmt.Fprintln(buffered, in)
+	}
+
+	return buffered.Flush()
+}
+
+func LoadInputs(path string) ([]Input, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadInputs(file)
+}
+
+func SaveInputs(path string, inputs []Input) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := WriteInputs(file, inputs); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,98 @@
+package emulator
+
+import (
+	"sync"
+	"time"
+)
+
+type lockstepFrame struct {
+	inputs []Input
+	done   chan struct{}
+}
+
+// Lockstep runs several emulators one 60 Hz frame at a time: none of them
+// starts a frame before all of them have finished the one before, and
+// input given to the lockstep reaches all of them at the same frame. They
+// all run at normal speed.
+type Lockstep struct {
+	emulators []*Emulator
+
+	mutex  sync.Mutex
+	inputs []Input
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+func NewLockstep(emulators []*Emulator) *Lockstep {
+	return &Lockstep{
+		emulators: emulators,
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start takes the emulators off their own tickers and runs them. The
+// commands sent to them before are done first.
+func (l *Lockstep) Start() {
+	l.setLockstep(true)
+	go l.run()
+}
+
+// Stop gives the emulators back their own tickers. It has to be called
+// before any of them quits.
+func (l *Lockstep) Stop() {
+	close(l.quit)
+	<-l.done
+	l.setLockstep(false)
+}
+
+// Input queues in for every VM before the next frame.
+func (l *Lockstep) Input(in Input) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.inputs = append(l.inputs, in)
+}
+
+func (l *Lockstep) setLockstep(on bool) {
+	for _, e := range l.emulators {
+		e := e
+		ready := make(chan struct{})
+		e.commands <- func() {
+			e.lockstep = on
+			close(ready)
+		}
+		<-ready
+	}
+}
+
+func (l *Lockstep) run() {
+	defer close(l.done)
+
+	video := time.NewTicker(VIDEO_HZ)
+	defer video.Stop()
+
+	for {
+		select {
+		case <-l.quit:
+			return
+		case <-video.C:
+		}
+
+		l.mutex.Lock()
+		step := lockstepFrame{
+			inputs: l.inputs,
+			done:   make(chan struct{}, len(l.emulators)),
+		}
+		l.inputs = nil
+		l.mutex.Unlock()
+
+		for _, e := range l.emulators {
+			e.steps <- step
+		}
+		for range l.emulators {
+			<-step.done
+		}
+	}
+}
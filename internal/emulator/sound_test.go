@@ -0,0 +1,124 @@
+package emulator
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/audio"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/chip8"
+)
+
+// beep sounds the buzzer for half a second, then loops silently.
+var beep = []byte{
+	0x6A, 0x1E, // 200: VA = 30
+	0xFA, 0x18, // 202: ST = VA
+	0x12, 0x04, // 204: loop
+}
+
+// renderWAV runs program for the given frames and returns the samples of
+// the first channel of the WAV file its sound was rendered to.
+func renderWAV(t *testing.T, program []byte, config chip8.Config, frames int) []int16 {
+	t.Helper()
+	vm, err := chip8.LoadROM(program, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "sound.wav")
+	wav, err := audio.NewWAVBackend(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := audio.NewAudio(wav)
+	if err := a.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	for frame := uint64(0); frame < uint64(frames); frame++ {
+		if err := RunFrame(vm, frame, CLOCK_RATE); err != nil {
+			t.Fatal(err)
+		}
+		Sound(vm, a)
+		if err := wav.WriteFrame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = data[audio.WAV_HEADER_SIZE:]
+	if want := frames * audio.WAV_FRAME_SAMPLES * audio.WAV_CHANNELS * 2; len(data) != want {
+		t.Fatalf("%d bytes of samples, want %d, one frame of samples per emulated frame", len(data), want)
+	}
+
+	samples := make([]int16, len(data)/audio.WAV_CHANNELS/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(data[i*audio.WAV_CHANNELS*2:]))
+	}
+
+	return samples
+}
+
+// frameSamples returns the samples of one 60 Hz frame.
+func frameSamples(samples []int16, frame int) []int16 {
+	return samples[frame*audio.WAV_FRAME_SAMPLES : (frame+1)*audio.WAV_FRAME_SAMPLES]
+}
+
+func loud(samples []int16) bool {
+	for _, sample := range samples {
+		if sample != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// frequency measures the pitch of samples by their zero crossings.
+func frequency(samples []int16) float64 {
+	crossings := 0
+	for i := 1; i < len(samples); i++ {
+		if (samples[i-1] < 0) != (samples[i] < 0) {
+			crossings++
+		}
+	}
+
+	return float64(crossings) / 2 / (float64(len(samples)) / audio.WAV_SAMPLE_RATE)
+}
+
+func TestSoundWAV(t *testing.T) {
+	const frames = 60
+	samples := renderWAV(t, beep, chip8.Config{}, frames)
+
+	if !loud(frameSamples(samples, 10)) {
+		t.Error("frame 10 is silent while the buzzer sounds")
+	}
+	if loud(frameSamples(samples, frames-1)) {
+		t.Error("the last frame isn't silent after the sound timer ran out")
+	}
+}
+
+func TestSoundCHIP8XTone(t *testing.T) {
+	program := []byte{
+		0x6B, 0x1A, // 300: VB = 26
+		0xFB, 0xF8, // 302: tone 27535 / 27 Hz
+		0x6A, 0x1E, // 304: VA = 30
+		0xFA, 0x18, // 306: ST = VA
+		0x13, 0x08, // 308: loop
+	}
+	samples := renderWAV(t, program, chip8.Config{Platform: chip8.PlatformChip8X}, 20)
+
+	// skip the frame the buzzer starts in
+	got := frequency(samples[5*audio.WAV_FRAME_SAMPLES : 15*audio.WAV_FRAME_SAMPLES])
+	want := 27535.0 / 27
+	if got < want*0.95 || got > want*1.05 {
+		t.Errorf("buzzer sounds at %.0f Hz, want the tone of %.0f Hz", got, want)
+	}
+}
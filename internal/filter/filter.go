@@ -0,0 +1,245 @@
+package filter
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"sync/atomic"
+)
+
+// Mode selects the filter that reduces the flicker of XOR drawn sprites.
+type Mode int
+
+const (
+	ModeNone Mode = iota
+	// ModePhosphor lets pixels that went dark fade out like on a CRT.
+	ModePhosphor
+	// ModeBlend mixes every frame with the previous one.
+	ModeBlend
+	// ModeOR shows a pixel lit if it was lit in any of the last frames.
+	ModeOR
+)
+
+const (
+	MIN_OR_FRAMES = 2
+	MAX_OR_FRAMES = 8
+)
+
+var modeNames = []string{"none", "phosphor", "blend", "or"}
+
+// Modes lists every mode, in the order shown to the user.
+var Modes = []Mode{ModeNone, ModePhosphor, ModeBlend, ModeOR}
+
+func (m Mode) String() string {
+	if int(m) >= 0 && int(m) < len(modeNames) {
+		return modeNames[m]
+	}
+
+	return modeNames[ModeNone]
+}
+
+func ParseMode(name string) (Mode, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i, modeName := range modeNames {
+		if modeName == name {
+			return Mode(i), nil
+		}
+	}
+
+	return ModeNone, fmt.Errorf("Unknown filter: %s", name)
+}
+
+func (m Mode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Mode) UnmarshalText(text []byte) error {
+	mode, err := ParseMode(string(text))
+	if err != nil {
+		return err
+	}
+
+	*m = mode
+	return nil
+}
+
+// Settings hold the filter and the strength of each mode. Phosphor is how
+// much of a dark pixel's light remains after each frame, Blend the weight
+// of the previous frame and Frames how many frames ModeOR combines.
+type Settings struct {
+	Mode     Mode    `json:"mode"`
+	Phosphor float64 `json:"phosphor"`
+	Blend    float64 `json:"blend"`
+	Frames   int     `json:"frames"`
+}
+
+func DefaultSettings() Settings {
+	return Settings{
+		Mode:     ModeNone,
+		Phosphor: 0.6,
+		Blend:    0.5,
+		Frames:   3,
+	}
+}
+
+// Validate checks that the settings are in range.
+func (s Settings) Validate() error {
+	if int(s.Mode) < 0 || int(s.Mode) >= len(modeNames) {
+		return fmt.Errorf("Invalid filter: %d", s.Mode)
+	}
+	if s.Phosphor < 0 || s.Phosphor > 1 || s.Blend < 0 || s.Blend > 1 {
+		return fmt.Errorf("Filter strengths must be between 0 and 1")
+	}
+	if s.Frames < MIN_OR_FRAMES || s.Frames > MAX_OR_FRAMES {
+		return fmt.Errorf("Frames must be between %d and %d, not %d", MIN_OR_FRAMES, MAX_OR_FRAMES, s.Frames)
+	}
+
+	return nil
+}
+
+// Filter turns the frames of the display into the pictures shown. It only
+// depends on the frames it is given, so the output is deterministic.
+// Settings can be changed from any goroutine; Apply is called from one.
+type Filter struct {
+	settings atomic.Value
+
+	mode Mode
+	// out, previous and the history are reused for every frame
+	out      *image.RGBA
+	previous *image.RGBA
+	history  []*image.RGBA
+}
+
+func New(settings Settings) *Filter {
+	f := Filter{}
+	f.SetSettings(settings)
+	return &f
+}
+
+func (f *Filter) Settings() Settings {
+	return f.settings.Load().(Settings)
+}
+
+// SetSettings changes the settings. Values out of range are clamped, as
+// the filter can't fail while drawing.
+func (f *Filter) SetSettings(settings Settings) {
+	f.settings.Store(settings.clamped())
+}
+
+func (s Settings) clamped() Settings {
+	if int(s.Mode) < 0 || int(s.Mode) >= len(modeNames) {
+		s.Mode = ModeNone
+	}
+	s.Phosphor = math.Max(0, math.Min(1, s.Phosphor))
+	s.Blend = math.Max(0, math.Min(1, s.Blend))
+	if s.Frames < MIN_OR_FRAMES {
+		s.Frames = MIN_OR_FRAMES
+	}
+	if s.Frames > MAX_OR_FRAMES {
+		s.Frames = MAX_OR_FRAMES
+	}
+
+	return s
+}
+
+// Apply returns the picture to show for frame. Pixels of the background
+// colour count as dark. frame is neither changed nor kept. The picture is
+// drawn in a buffer of the filter, which the next Apply draws over.
+func (f *Filter) Apply(frame *image.RGBA, background color.RGBA) *image.RGBA {
+	settings := f.Settings()
+
+	// start over when the mode or the resolution changes
+	if settings.Mode != f.mode || (f.out != nil && f.out.Rect != frame.Rect) {
+		f.mode = settings.Mode
+		f.previous = nil
+		f.history = nil
+	}
+
+	f.out = copyImage(f.out, frame)
+	out := f.out
+
+	switch settings.Mode {
+	case ModePhosphor:
+		if f.previous != nil {
+			phosphor(out, f.previous, background, settings.Phosphor)
+		}
+		f.previous = copyImage(f.previous, out)
+	case ModeBlend:
+		if f.previous != nil {
+			blend(out, f.previous, settings.Blend)
+		}
+		f.previous = copyImage(f.previous, frame)
+	case ModeOR:
+		for _, old := range f.history {
+			or(out, old, background)
+		}
+		// keep the last Frames-1 frames, drawing the new one over the
+		// oldest dropped
+		var spare *image.RGBA
+		if n := len(f.history); n > 0 && n >= settings.Frames-1 {
+			spare = f.history[0]
+			f.history = append(f.history[:0], f.history[n-settings.Frames+2:]...)
+		}
+		f.history = append(f.history, copyImage(spare, frame))
+	}
+
+	return out
+}
+
+// phosphor fades dark pixels of out from what was shown before.
+func phosphor(out, shown *image.RGBA, background color.RGBA, strength float64) {
+	weight := int(strength * 255)
+
+	for i := 0; i < len(out.Pix); i += 4 {
+		if !isBackground(out.Pix[i:i+4], background) {
+			continue
+		}
+
+		out.Pix[i] = mix(background.R, shown.Pix[i], weight)
+		out.Pix[i+1] = mix(background.G, shown.Pix[i+1], weight)
+		out.Pix[i+2] = mix(background.B, shown.Pix[i+2], weight)
+	}
+}
+
+// blend mixes out with the previous frame.
+func blend(out, previous *image.RGBA, strength float64) {
+	// at full strength both frames count the same
+	weight := int(strength * 128)
+
+	for i := 0; i < len(out.Pix); i += 4 {
+		out.Pix[i] = mix(out.Pix[i], previous.Pix[i], weight)
+		out.Pix[i+1] = mix(out.Pix[i+1], previous.Pix[i+1], weight)
+		out.Pix[i+2] = mix(out.Pix[i+2], previous.Pix[i+2], weight)
+	}
+}
+
+// or lights dark pixels of out that are lit in old.
+func or(out, old *image.RGBA, background color.RGBA) {
+	for i := 0; i < len(out.Pix); i += 4 {
+		if isBackground(out.Pix[i:i+4], background) && !isBackground(old.Pix[i:i+4], background) {
+			copy(out.Pix[i:i+4], old.Pix[i:i+4])
+		}
+	}
+}
+
+// mix moves a towards b by weight/256.
+func mix(a, b byte, weight int) byte {
+	return byte(int(a) + (int(b)-int(a))*weight/256)
+}
+
+func isBackground(pixel []byte, background color.RGBA) bool {
+	return pixel[0] == background.R && pixel[1] == background.G && pixel[2] == background.B
+}
+
+// copyImage copies src into dst, which is allocated again only when it is
+// nil or of another size.
+func copyImage(dst, src *image.RGBA) *image.RGBA {
+	if dst == nil || dst.Rect != src.Rect {
+		dst = image.NewRGBA(src.Rect)
+	}
+	copy(dst.Pix, src.Pix)
+
+	return dst
+}
@@ -0,0 +1,144 @@
+package filter
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+var background = color.RGBA{A: 255}
+
+// grayFrame makes a frame one pixel high with the given gray levels, where
+// 0 is the background.
+func grayFrame(levels ...byte) *image.RGBA {
+	frame := image.NewRGBA(image.Rect(0, 0, len(levels), 1))
+	for x, level := range levels {
+		frame.SetRGBA(x, 0, color.RGBA{level, level, level, 255})
+	}
+
+	return frame
+}
+
+func grayLevels(img *image.RGBA) []byte {
+	levels := make([]byte, img.Rect.Dx())
+	for x := range levels {
+		levels[x] = img.RGBAAt(x, 0).R
+	}
+
+	return levels
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings Settings
+		frames   [][]byte
+		// want is the picture shown for each frame
+		want [][]byte
+	}{
+		{
+			name:     "none",
+			settings: Settings{Mode: ModeNone, Frames: 2},
+			frames:   [][]byte{{255, 0}, {0, 255}},
+			want:     [][]byte{{255, 0}, {0, 255}},
+		},
+		{
+			name:     "phosphor fades dark pixels",
+			settings: Settings{Mode: ModePhosphor, Phosphor: 0.5, Frames: 2},
+			frames:   [][]byte{{255, 0}, {0, 0}, {0, 255}},
+			want:     [][]byte{{255, 0}, {126, 0}, {62, 255}},
+		},
+		{
+			name:     "phosphor at zero strength",
+			settings: Settings{Mode: ModePhosphor, Phosphor: 0, Frames: 2},
+			frames:   [][]byte{{255, 0}, {0, 255}},
+			want:     [][]byte{{255, 0}, {0, 255}},
+		},
+		{
+			name:     "blend mixes with the previous frame",
+			settings: Settings{Mode: ModeBlend, Blend: 0.5, Frames: 2},
+			frames:   [][]byte{{255, 0}, {0, 255}, {0, 255}},
+			want:     [][]byte{{255, 0}, {63, 192}, {0, 255}},
+		},
+		{
+			name:     "or keeps pixels of the last frames",
+			settings: Settings{Mode: ModeOR, Frames: 3},
+			frames:   [][]byte{{255, 0, 0}, {0, 255, 0}, {0, 0, 0}, {0, 0, 0}, {0, 0, 255}},
+			want:     [][]byte{{255, 0, 0}, {255, 255, 0}, {255, 255, 0}, {0, 255, 0}, {0, 0, 255}},
+		},
+		{
+			name:     "or of two frames",
+			settings: Settings{Mode: ModeOR, Frames: 2},
+			frames:   [][]byte{{255, 0}, {0, 255}, {0, 0}},
+			want:     [][]byte{{255, 0}, {255, 255}, {0, 255}},
+		},
+		{
+			name:     "or of too few frames is or of two",
+			settings: Settings{Mode: ModeOR, Frames: 0},
+			frames:   [][]byte{{255, 0}, {0, 255}, {0, 0}},
+			want:     [][]byte{{255, 0}, {255, 255}, {0, 255}},
+		},
+		{
+			name:     "or of one frame is or of two",
+			settings: Settings{Mode: ModeOR, Frames: 1},
+			frames:   [][]byte{{255, 0}, {0, 255}, {0, 0}},
+			want:     [][]byte{{255, 0}, {255, 255}, {0, 255}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := New(test.settings)
+			for i, levels := range test.frames {
+				got := grayLevels(f.Apply(grayFrame(levels...), background))
+				if string(got) != string(test.want[i]) {
+					t.Fatalf("frame %d shows %v, want %v", i, got, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestApplyReusesBuffers(t *testing.T) {
+	for _, mode := range Modes {
+		settings := DefaultSettings()
+		settings.Mode = mode
+		f := New(settings)
+		frames := []*image.RGBA{grayFrame(255, 0), grayFrame(0, 255)}
+		for _, frame := range frames {
+			f.Apply(frame, background)
+		}
+
+		allocs := testing.AllocsPerRun(100, func() {
+			for _, frame := range frames {
+				f.Apply(frame, background)
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("%s allocates %.0f times per frame", mode, allocs/2)
+		}
+	}
+}
+
+func TestSetSettingsClamps(t *testing.T) {
+	f := New(Settings{Mode: ModeOR, Frames: MAX_OR_FRAMES})
+	for i := 0; i < MAX_OR_FRAMES; i++ {
+		f.Apply(grayFrame(255, 0), background)
+	}
+
+	// fewer frames than kept
+	f.SetSettings(Settings{Mode: ModeOR, Phosphor: -1, Blend: 2, Frames: -3})
+	want := Settings{Mode: ModeOR, Phosphor: 0, Blend: 1, Frames: MIN_OR_FRAMES}
+	if got := f.Settings(); got != want {
+		t.Errorf("settings are %+v, want %+v", got, want)
+	}
+	f.Apply(grayFrame(0, 255), background)
+	if got := grayLevels(f.Apply(grayFrame(0, 0), background)); string(got) != string([]byte{0, 255}) {
+		t.Errorf("shows %v, want the last two frames", got)
+	}
+
+	f.SetSettings(Settings{Mode: Mode(len(Modes)), Frames: 100})
+	if got := f.Settings(); got.Mode != ModeNone || got.Frames != MAX_OR_FRAMES {
+		t.Errorf("settings are %+v, want no filter of %d frames", got, MAX_OR_FRAMES)
+	}
+}
@@ -39,6 +39,17 @@ type GLFW struct {
 	time             float64
 	mouseJustPressed [3]bool
 	onDropCallback   func([]string)
+
+	// scancodes are the physical keys held down
+	scancodes map[int]bool
+	// pressed is the first key pressed in the last ProcessEvents
+	pressed *KeyPress
+}
+
+// KeyPress is a key going down, by its GLFW key code and its scancode.
+type KeyPress struct {
+	Key      int
+	Scancode int
 }
 
 func init() {
@@ -75,8 +86,9 @@ func NewGLFW(io imgui.IO, clientAPI GLFWClientAPI, width, height int, title stri
 	glfw.SwapInterval(1)
 
 	platform := &GLFW{
-		imguiIO: io,
-		window:  window,
+		imguiIO:   io,
+		window:    window,
+		scancodes: map[int]bool{},
 	}
 	platform.setKeyMapping()
 	platform.installCallbacks()
@@ -97,15 +109,47 @@ func (platform *GLFW) ShouldStop() bool {
 
 // ProcessEvents handles all pending window events.
 func (platform *GLFW) ProcessEvents() {
+	platform.pressed = nil
 	glfw.PollEvents()
 }
 
+// KeyPressed returns the first key pressed in the last ProcessEvents.
+func (platform *GLFW) KeyPressed() (KeyPress, bool) {
+	if platform.pressed == nil {
+		return KeyPress{}, false
+	}
+
+	return *platform.pressed, true
+}
+
+// ScancodeDown tells whether the key with the given scancode is held down.
+func (platform *GLFW) ScancodeDown(scancode int) bool {
+	return platform.scancodes[scancode]
+}
+
 // DisplaySize returns the dimension of the display.
 func (platform *GLFW) DisplaySize() [2]float32 {
 	w, h := platform.window.GetSize()
 	return [2]float32{float32(w), float32(h)}
 }
 
+// SetSize resizes the window.
+func (platform *GLFW) SetSize(width, height int) {
+	platform.window.SetSize(width, height)
+}
+
+// SetMinSize keeps the window from getting smaller than the given size.
+func (platform *GLFW) SetMinSize(width, height int) {
+	platform.window.SetSizeLimits(width, height, glfw.DontCare, glfw.DontCare)
+}
+
+// SetFullscreen shows the window on the whole primary monitor.
+func (platform *GLFW) SetFullscreen() {
+	monitor := glfw.GetPrimaryMonitor()
+	mode := monitor.GetVideoMode()
+	platform.window.SetMonitor(monitor, 0, 0, mode.Width, mode.Height, mode.RefreshRate)
+}
+
 // FramebufferSize returns the dimension of the framebuffer.
 func (platform *GLFW) FramebufferSize() [2]float32 {
 	w, h := platform.window.GetFramebufferSize()
@@ -205,9 +249,14 @@ func (platform *GLFW) mouseScrollChange(window *glfw.Window, x, y float64) {
 func (platform *GLFW) keyChange(window *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
 	if action == glfw.Press {
 		platform.imguiIO.KeyPress(int(key))
+		platform.scancodes[scancode] = true
+		if platform.pressed == nil {
+			platform.pressed = &KeyPress{Key: int(key), Scancode: scancode}
+		}
 	}
 	if action == glfw.Release {
 		platform.imguiIO.KeyRelease(int(key))
+		delete(platform.scancodes, scancode)
 	}
 
 	// Modifiers are not reliable across systems
@@ -429,3 +429,55 @@ func (renderer *OpenGL3) CreateImageTexture(img *image.RGBA) (imgui.TextureID, e
 
 	return imgui.TextureID(handle), nil
 }
+
+// ImageTexture is a texture that is allocated once and then updated in
+// place, for images that change every frame.
+type ImageTexture struct {
+	ID     imgui.TextureID
+	width  int
+	height int
+}
+
+// UpdateImageTexture uploads img into texture. The texture is only
+// (re)allocated when it doesn't exist yet or the size of img has changed.
+func (renderer *OpenGL3) UpdateImageTexture(texture *ImageTexture, img *image.RGBA) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	var lastTexture int32
+	gl.GetIntegerv(gl.TEXTURE_BINDING_2D, &lastTexture)
+
+	if texture.ID == 0 {
+		var handle uint32
+		gl.GenTextures(1, &handle)
+		gl.BindTexture(gl.TEXTURE_2D, handle)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, renderer.textureMinFilter)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, renderer.textureMagFilter)
+		texture.ID = imgui.TextureID(handle)
+	} else {
+		gl.BindTexture(gl.TEXTURE_2D, uint32(texture.ID))
+	}
+
+	gl.PixelStorei(gl.UNPACK_ROW_LENGTH, int32(img.Stride/4))
+	if texture.width != width || texture.height != height {
+		gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, int32(width), int32(height), 0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(img.Pix))
+		texture.width = width
+		texture.height = height
+	} else {
+		gl.TexSubImage2D(gl.TEXTURE_2D, 0, 0, 0, int32(width), int32(height), gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(img.Pix))
+	}
+	gl.PixelStorei(gl.UNPACK_ROW_LENGTH, 0)
+
+	// Restore state
+	gl.BindTexture(gl.TEXTURE_2D, uint32(lastTexture))
+}
+
+// ReleaseImageTexture deletes the texture. It can be updated again
+// afterwards, which allocates a new one.
+func (renderer *OpenGL3) ReleaseImageTexture(texture *ImageTexture) {
+	if texture.ID == 0 {
+		return
+	}
+
+	renderer.ReleaseImage(texture.ID)
+	*texture = ImageTexture{}
+}
@@ -0,0 +1,193 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CAPACITY is how many entries a Logger keeps. Older entries are dropped.
+const CAPACITY = 1000
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// Levels lists the levels from the least to the most severe.
+var Levels = []Level{LevelDebug, LevelInfo, LevelWarn, LevelError}
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("level %d", int(l))
+}
+
+func ParseLevel(name string) (Level, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, level := range Levels {
+		if level.String() == name {
+			return level, nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("Unknown log level: %s (one of debug, info, warn, error)", name)
+}
+
+// Entry is one logged message.
+type Entry struct {
+	Time  time.Time
+	Level Level
+	// Subsystem is the part of the emulator the message comes from
+	Subsystem string
+	Message   string
+}
+
+func (e Entry) String() string {
+	return fmt.Sprintf("%s %-5s [%s] %s", e.Time.Format("15:04:05.000"), strings.ToUpper(e.Level.String()), e.Subsystem, e.Message)
+}
+
+// Filter selects entries of at least Level whose subsystem or message
+// contain Text, ignoring case.
+type Filter struct {
+	Level Level
+	Text  string
+}
+
+func (f Filter) Match(e Entry) bool {
+	if e.Level < f.Level {
+		return false
+	}
+	text := strings.ToLower(f.Text)
+
+	return strings.Contains(strings.ToLower(e.Message), text) || strings.Contains(strings.ToLower(e.Subsystem), text)
+}
+
+// Logger keeps the last CAPACITY entries in a ring buffer and can also
+// write every entry to an output as it is logged. It is safe for
+// concurrent use.
+type Logger struct {
+	mu      sync.Mutex
+	entries []Entry
+	// next is where the next entry goes once entries is full
+	next int
+
+	output      io.Writer
+	outputLevel Level
+}
+
+func New() *Logger {
+	return &Logger{entries: make([]Entry, 0, CAPACITY)}
+}
+
+// SetOutput writes the entries of at least level to w from now on, or
+// stops writing them if w is nil.
+func (l *Logger) SetOutput(w io.Writer, level Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.output = w
+	l.outputLevel = level
+}
+
+func (l *Logger) Log(level Level, subsystem, message string) {
+	entry := Entry{
+		Time:      time.Now(),
+		Level:     level,
+		Subsystem: subsystem,
+		Message:   message,
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.entries) < cap(l.entries) {
+		l.entries = append(l.entries, entry)
+	} else {
+		l.entries[l.next] = entry
+		l.next = (l.next + 1) % len(l.entries)
+	}
+
+	if l.output != nil && level >= l.outputLevel {
+		fmt.Fprintln(l.output, entry)
+	}
+}
+
+func (l *Logger) Debugf(subsystem, format string, args ...interface{}) {
+	l.Log(LevelDebug, subsystem, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Infof(subsystem, format string, args ...interface{}) {
+	l.Log(LevelInfo, subsystem, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Warnf(subsystem, format string, args ...interface{}) {
+	l.Log(LevelWarn, subsystem, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Errorf(subsystem, format string, args ...interface{}) {
+	l.Log(LevelError, subsystem, fmt.Sprintf(format, args...))
+}
+
+// Entries returns the entries kept, oldest first.
+func (l *Logger) Entries() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := make([]Entry, 0, len(l.entries))
+	entries = append(entries, l.entries[l.next:]...)
+	return append(entries, l.entries[:l.next]...)
+}
+
+// Clear drops all entries kept.
+func (l *Logger) Clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = l.entries[:0]
+	l.next = 0
+}
+
+// Text returns the entries that match filter, one per line.
+func (l *Logger) Text(filter Filter) string {
+	var sb strings.Builder
+	for _, entry := range l.Entries() {
+		if filter.Match(entry) {
+			sb.WriteString(entry.String())
+			sb.WriteString("\n")
+		}
+	}
+
+	return sb.String()
+}
+
+// Export writes the entries that match filter to a text file.
+func (l *Logger) Export(path string, filter Filter) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.WriteString(l.Text(filter)); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,133 @@
+package palette
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"strings"
+)
+
+// Colors are indexed by the value of a pixel: 0 is the background, 1 the
+// first plane, 2 the second plane and 3 both planes. Single plane programs
+// only use the first two.
+const (
+	BACKGROUND = iota
+	FOREGROUND
+	PLANE2
+	BOTH_PLANES
+
+	COLORS
+)
+
+// Palette is a named set of display colours.
+type Palette struct {
+	Name   string
+	Colors [COLORS]color.RGBA
+}
+
+// Presets are the built-in palettes. The first one is the default.
+var Presets = []Palette{
+	{Name: "Default", Colors: [COLORS]color.RGBA{
+		{R: 50, G: 50, B: 54, A: 255},
+		{R: 156, G: 220, B: 254, A: 255},
+		{R: 86, G: 156, B: 214, A: 255},
+		{R: 220, G: 220, B: 170, A: 255},
+	}},
+	{Name: "Green phosphor", Colors: [COLORS]color.RGBA{
+		{R: 8, G: 24, B: 8, A: 255},
+		{R: 51, G: 255, B: 51, A: 255},
+		{R: 0, G: 153, B: 51, A: 255},
+		{R: 179, G: 255, B: 179, A: 255},
+	}},
+	{Name: "Amber", Colors: [COLORS]color.RGBA{
+		{R: 26, G: 15, B: 0, A: 255},
+		{R: 255, G: 176, B: 0, A: 255},
+		{R: 178, G: 98, B: 0, A: 255},
+		{R: 255, G: 224, B: 140, A: 255},
+	}},
+	{Name: "LCD (HP48)", Colors: [COLORS]color.RGBA{
+		{R: 175, G: 188, B: 155, A: 255},
+		{R: 40, G: 48, B: 36, A: 255},
+		{R: 104, G: 116, B: 92, A: 255},
+		{R: 0, G: 0, B: 0, A: 255},
+	}},
+	{Name: "Octo", Colors: [COLORS]color.RGBA{
+		{R: 0x99, G: 0x66, B: 0x00, A: 255},
+		{R: 0xFF, G: 0xCC, B: 0x00, A: 255},
+		{R: 0xFF, G: 0x66, B: 0x00, A: 255},
+		{R: 0x66, G: 0x22, B: 0x00, A: 255},
+	}},
+	{Name: "High contrast", Colors: [COLORS]color.RGBA{
+		{R: 0, G: 0, B: 0, A: 255},
+		{R: 255, G: 255, B: 255, A: 255},
+		{R: 255, G: 255, B: 0, A: 255},
+		{R: 0, G: 255, B: 255, A: 255},
+	}},
+}
+
+func Default() Palette {
+	return Presets[0]
+}
+
+// Find returns the preset with the given name, ignoring case.
+func Find(name string) (Palette, error) {
+	for _, preset := range Presets {
+		if strings.EqualFold(preset.Name, name) {
+			return preset, nil
+		}
+	}
+
+	return Default(), fmt.Errorf("Unknown palette: %s", name)
+}
+
+// Color returns the colour of a pixel value.
+func (p Palette) Color(value byte) color.RGBA {
+	return p.Colors[value%COLORS]
+}
+
+// Palettes are stored with their colours as "#RRGGBB".
+type storedPalette struct {
+	Name   string         `json:"name"`
+	Colors [COLORS]string `json:"colors"`
+}
+
+func (p Palette) MarshalJSON() ([]byte, error) {
+	stored := storedPalette{Name: p.Name}
+	for i, c := range p.Colors {
+		stored.Colors[i] = FormatColor(c)
+	}
+
+	return json.Marshal(stored)
+}
+
+func (p *Palette) UnmarshalJSON(data []byte) error {
+	var stored storedPalette
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+
+	p.Name = stored.Name
+	for i, text := range stored.Colors {
+		c, err := ParseColor(text)
+		if err != nil {
+			return err
+		}
+		p.Colors[i] = c
+	}
+
+	return nil
+}
+
+func FormatColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+}
+
+// ParseColor reads a colour written as "#RRGGBB".
+func ParseColor(text string) (color.RGBA, error) {
+	c := color.RGBA{A: 255}
+	if _, err := fmt.Sscanf(text, "#%02x%02x%02x", &c.R, &c.G, &c.B); err != nil || len(text) != 7 {
+		return c, fmt.Errorf("Invalid colour: %s", text)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,43 @@
+package raster
+
+import "image"
+
+// Scale draws src into all of dst, enlarged with nearest neighbour
+// sampling. It writes Pix directly: each source pixel is filled into its
+// span of the row, and rows that show the same source row are copied.
+func Scale(dst, src *image.RGBA) {
+	dstWidth, dstHeight := dst.Rect.Dx(), dst.Rect.Dy()
+	srcWidth, srcHeight := src.Rect.Dx(), src.Rect.Dy()
+	if dstWidth == 0 || dstHeight == 0 || srcWidth == 0 || srcHeight == 0 {
+		return
+	}
+
+	rowLength := dstWidth * 4
+	lastSrcY := -1
+
+	for y := 0; y < dstHeight; y++ {
+		row := dst.Pix[y*dst.Stride : y*dst.Stride+rowLength]
+
+		srcY := y * srcHeight / dstHeight
+		if srcY == lastSrcY {
+			copy(row, dst.Pix[(y-1)*dst.Stride:])
+			continue
+		}
+		lastSrcY = srcY
+
+		srcRow := src.Pix[srcY*src.Stride:]
+		for x := 0; x < dstWidth; {
+			srcX := x * srcWidth / dstWidth
+			// the first x that shows the next source pixel
+			end := ((srcX+1)*dstWidth + srcWidth - 1) / srcWidth
+
+			span := row[x*4 : end*4]
+			copy(span, srcRow[srcX*4:srcX*4+4])
+			for filled := 4; filled < len(span); filled *= 2 {
+				copy(span[filled:], span[:filled])
+			}
+
+			x = end
+		}
+	}
+}
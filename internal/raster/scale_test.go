@@ -0,0 +1,52 @@
+package raster
+
+import (
+	"fmt"
+	"image"
+	"testing"
+)
+
+// BenchmarkScale scales the video resolutions onto the display of the
+// default scale, where 64x64 is fitted into 640x320 like session does.
+func BenchmarkScale(b *testing.B) {
+	cases := []struct {
+		src, dst image.Point
+	}{
+		{image.Pt(64, 32), image.Pt(640, 320)},
+		{image.Pt(128, 64), image.Pt(640, 320)},
+		{image.Pt(64, 64), image.Pt(320, 320)},
+	}
+
+	for _, c := range cases {
+		src := image.NewRGBA(image.Rectangle{Max: c.src})
+		for i := range src.Pix {
+			src.Pix[i] = byte(i)
+		}
+		dst := image.NewRGBA(image.Rectangle{Max: c.dst})
+
+		b.Run(fmt.Sprintf("%dx%d", c.src.X, c.src.Y), func(b *testing.B) {
+			b.SetBytes(int64(len(dst.Pix)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				Scale(dst, src)
+			}
+		})
+	}
+}
+
+func TestScale(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := range src.Pix {
+		src.Pix[i] = byte(i)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, 9, 4))
+	Scale(dst, src)
+
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 9; x++ {
+			if got, want := dst.RGBAAt(x, y), src.RGBAAt(x/3, y/2); got != want {
+				t.Fatalf("pixel %d,%d is %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/raster"
+)
+
+// Format is the file format recordings are saved in.
+type Format int
+
+const (
+	FormatGIF Format = iota
+	// FormatPNG saves a numbered PNG file per 60 Hz frame.
+	FormatPNG
+)
+
+const (
+	MIN_SCALE = 1
+	MAX_SCALE = 10
+)
+
+// MIN_GIF_DELAY is the shortest time in 1/100 s a GIF frame is shown for.
+// Players show frames with shorter delays much longer instead.
+const MIN_GIF_DELAY = 2
+
+func (f Format) String() string {
+	switch f {
+	case FormatPNG:
+		return "png"
+	default:
+		return "gif"
+	}
+}
+
+// Extension returns the file extension of the format, with the dot.
+func (f Format) Extension() string {
+	return "." + f.String()
+}
+
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), ".")) {
+	case "gif":
+		return FormatGIF, nil
+	case "png":
+		return FormatPNG, nil
+	}
+
+	return FormatGIF, fmt.Errorf("Unknown recording format: %s", name)
+}
+
+// Save writes frames to path, scaled up by scale. A PNG sequence is
+// written next to path as name_00000.png, name_00001.png and so on.
+func Save(path string, frames []Frame, format Format, scale int) error {
+	if len(frames) == 0 {
+		return errors.New("Nothing was recorded")
+	}
+	if scale < MIN_SCALE || scale > MAX_SCALE {
+		return fmt.Errorf("Scale must be between %d and %d, not %d", MIN_SCALE, MAX_SCALE, scale)
+	}
+
+	switch format {
+	case FormatPNG:
+		return savePNG(path, frames, scale)
+	default:
+		return saveGIF(path, frames, scale)
+	}
+}
+
+func saveGIF(path string, frames []Frame, scale int) error {
+	colors := framePalette(frames)
+
+	// GIF delays are in 1/100 s, so the rounding is spread over the frames
+	// instead of being lost on every one
+	centiseconds := func(ticks int) int {
+		return ticks * 100 / FRAME_RATE
+	}
+
+	animation := gif.GIF{}
+	start, ticks := 0, 0
+	for _, frame := range frames {
+		n := len(animation.Image)
+		if delay := centiseconds(ticks) - centiseconds(start); n > 0 && delay < MIN_GIF_DELAY {
+			// the last frame would be shown too shortly, this one takes
+			// its place
+			animation.Image[n-1] = toPaletted(frame.Image, colors, scale)
+		} else {
+			if n > 0 {
+				animation.Delay[n-1] = delay
+			}
+			start = ticks
+			animation.Image = append(animation.Image, toPaletted(frame.Image, colors, scale))
+			animation.Delay = append(animation.Delay, 0)
+		}
+		ticks += frame.Duration
+	}
+	last := len(animation.Delay) - 1
+	animation.Delay[last] = centiseconds(ticks) - centiseconds(start)
+	if animation.Delay[last] < MIN_GIF_DELAY {
+		if last > 0 {
+			// the last frame takes the place of the one before
+			animation.Delay[last-1] += animation.Delay[last]
+			animation.Image[last-1] = animation.Image[last]
+			animation.Image = animation.Image[:last]
+			animation.Delay = animation.Delay[:last]
+		} else {
+			animation.Delay[last] = MIN_GIF_DELAY
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := gif.EncodeAll(file, &animation); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func savePNG(path string, frames []Frame, scale int) error {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+
+	number := 0
+	for _, frame := range frames {
+		img := scaleImage(frame.Image, scale)
+		for i := 0; i < frame.Duration; i++ {
+			if err := writePNG(fmt.Sprintf("%s_%05d.png", base, number), img); err != nil {
+				return err
+			}
+			number++
+		}
+	}
+
+	return nil
+}
+
+func writePNG(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// framePalette returns the colours used in frames, or a general palette if
+// there are more than a GIF can hold.
+func framePalette(frames []Frame) color.Palette {
+	seen := map[color.RGBA]bool{}
+	colors := color.Palette{}
+
+	for _, frame := range frames {
+		pix := frame.Image.Pix
+		for i := 0; i < len(pix); i += 4 {
+			c := color.RGBA{R: pix[i], G: pix[i+1], B: pix[i+2], A: pix[i+3]}
+			if seen[c] {
+				continue
+			}
+			if len(colors) == 256 {
+				return palette.Plan9
+			}
+			seen[c] = true
+			colors = append(colors, c)
+		}
+	}
+
+	return colors
+}
+
+func toPaletted(src *image.RGBA, colors color.Palette, scale int) *image.Paletted {
+	bounds := src.Bounds()
+	small := image.NewPaletted(bounds, colors)
+	draw.Draw(small, bounds, src, bounds.Min, draw.Src)
+
+	if scale == 1 {
+		return small
+	}
+
+	dst := image.NewPaletted(image.Rect(0, 0, bounds.Dx()*scale, bounds.Dy()*scale), colors)
+	for y := 0; y < dst.Rect.Dy(); y++ {
+		for x := 0; x < dst.Rect.Dx(); x++ {
+			dst.SetColorIndex(x, y, small.ColorIndexAt(x/scale, y/scale))
+		}
+	}
+
+	return dst
+}
+
+func scaleImage(src *image.RGBA, scale int) *image.RGBA {
+	if scale == 1 {
+		return src
+	}
+
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx()*scale, bounds.Dy()*scale))
+	raster.Scale(dst, src)
+
+	return dst
+}
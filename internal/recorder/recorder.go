@@ -0,0 +1,174 @@
+package recorder
+
+import (
+	"bytes"
+	"image"
+	"sync"
+
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/chip8"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/palette"
+)
+
+const (
+	FRAME_RATE = 60
+
+	// REPLAY_FRAMES is how much the instant replay keeps (10 seconds).
+	REPLAY_FRAMES = 10 * FRAME_RATE
+	// REPLAY_MAX_BYTES caps the pictures the instant replay keeps. It holds
+	// less than 10 seconds only of large displays that change every frame,
+	// like MEGA-CHIP's.
+	REPLAY_MAX_BYTES = 32 << 20
+)
+
+// Frame is a captured picture of the display, shown for Duration frames
+// (1/60 s each). Identical consecutive frames are merged into one.
+type Frame struct {
+	Image    *image.RGBA
+	Duration int
+}
+
+// Recorder captures one frame per 60 Hz tick. It always keeps the last
+// REPLAY_FRAMES frames for an instant replay, and everything from Start to
+// Stop while recording. It is safe for concurrent use.
+type Recorder struct {
+	mu sync.Mutex
+
+	recording bool
+	frames    []Frame
+
+	replay         []Frame
+	replayDuration int
+	replayBytes    int
+}
+
+func New() *Recorder {
+	return &Recorder{}
+}
+
+// Capture renders the VM's display at its native resolution in the
+// colours of p. CHIP-8X and MEGA-CHIP programs bring their own colours.
+// The picture is drawn into frame, which is allocated again only when it
+// is nil or of another resolution.
+func Capture(frame *image.RGBA, vm *chip8.VirtualMachine, p palette.Palette) *image.RGBA {
+	video := vm.Video
+	if rect := image.Rect(0, 0, len(video[0]), len(video)); frame == nil || frame.Rect != rect {
+		frame = image.NewRGBA(rect)
+	}
+
+	if vm.Frame != nil {
+		copy(frame.Pix, vm.Frame.Pix)
+		return frame
+	}
+
+	for y := range video {
+		for x := range video[y] {
+			if vm.ColorMap == nil {
+				frame.SetRGBA(x, y, p.Color(video[y][x]))
+			} else if video[y][x] == 0 {
+				frame.SetRGBA(x, y, vm.BackgroundColor())
+			} else {
+				frame.SetRGBA(x, y, vm.ForegroundColor(x, y))
+			}
+		}
+	}
+
+	return frame
+}
+
+// AddFrame adds the picture of one 60 Hz tick. The recorder keeps a copy
+// of frame when it differs from the last one, so frame can be drawn over
+// afterwards.
+func (r *Recorder) AddFrame(frame *image.RGBA) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if n := len(r.replay); n == 0 || !SameImage(r.replay[n-1].Image, frame) {
+		frame = &image.RGBA{
+			Pix:    append([]byte(nil), frame.Pix...),
+			Stride: frame.Stride,
+			Rect:   frame.Rect,
+		}
+		r.replayBytes += len(frame.Pix)
+	} else {
+		// the copy kept for the last frame
+		frame = r.replay[n-1].Image
+	}
+
+	if r.recording {
+		r.frames = appendFrame(r.frames, frame)
+	}
+
+	r.replay = appendFrame(r.replay, frame)
+	r.replayDuration++
+	for r.replayDuration > REPLAY_FRAMES || (r.replayBytes > REPLAY_MAX_BYTES && len(r.replay) > 1) {
+		r.replay[0].Duration--
+		r.replayDuration--
+		if r.replay[0].Duration == 0 {
+			r.replayBytes -= len(r.replay[0].Image.Pix)
+			r.replay[0] = Frame{}
+			r.replay = r.replay[1:]
+		}
+	}
+}
+
+func appendFrame(frames []Frame, frame *image.RGBA) []Frame {
+	if n := len(frames); n > 0 && (frames[n-1].Image == frame || SameImage(frames[n-1].Image, frame)) {
+		frames[n-1].Duration++
+		return frames
+	}
+
+	return append(frames, Frame{Image: frame, Duration: 1})
+}
+
+// SameImage tells whether two frames show the same picture.
+func SameImage(a, b *image.RGBA) bool {
+	return a.Rect == b.Rect && bytes.Equal(a.Pix, b.Pix)
+}
+
+// Start begins a new recording.
+func (r *Recorder) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.recording = true
+	r.frames = nil
+}
+
+// Stop ends the recording and returns its frames.
+func (r *Recorder) Stop() []Frame {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	frames := r.frames
+	r.recording = false
+	r.frames = nil
+
+	return frames
+}
+
+func (r *Recorder) Recording() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.recording
+}
+
+// Last returns the picture added last, or nil. The recorder never changes
+// it.
+func (r *Recorder) Last() *image.RGBA {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.replay) == 0 {
+		return nil
+	}
+	return r.replay[len(r.replay)-1].Image
+}
+
+// Replay returns the frames of the last 10 seconds.
+func (r *Recorder) Replay() []Frame {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Frame(nil), r.replay...)
+}
@@ -0,0 +1,138 @@
+package recorder
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// solidFrame makes a 2x2 frame of one gray level.
+func solidFrame(level byte) *image.RGBA {
+	frame := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for i := range frame.Pix {
+		frame.Pix[i] = level
+	}
+
+	return frame
+}
+
+func loadGIF(t *testing.T, frames []Frame) *gif.GIF {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.gif")
+	if err := Save(path, frames, FormatGIF, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	animation, err := gif.DecodeAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return animation
+}
+
+func TestSaveGIFDelays(t *testing.T) {
+	tests := []struct {
+		name      string
+		durations []int
+		// want is the whole animation in 1/100 s
+		want int
+	}{
+		{"single frame", []int{1}, MIN_GIF_DELAY},
+		{"every frame changes", []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, 16},
+		{"long frames", []int{30, 60, 90}, 300},
+		{"short frame before a long one", []int{1, 60}, 101},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var frames []Frame
+			for i, duration := range test.durations {
+				frames = append(frames, Frame{Image: solidFrame(byte(i * 20)), Duration: duration})
+			}
+			animation := loadGIF(t, frames)
+
+			total := 0
+			for i, delay := range animation.Delay {
+				if delay < MIN_GIF_DELAY {
+					t.Errorf("frame %d is shown for %d/100 s", i, delay)
+				}
+				total += delay
+			}
+			if total != test.want {
+				t.Errorf("animation takes %d/100 s, want %d", total, test.want)
+			}
+
+			// the last frame is never dropped
+			last := animation.Image[len(animation.Image)-1]
+			want := byte((len(test.durations) - 1) * 20)
+			if r, _, _, _ := last.At(0, 0).RGBA(); byte(r>>8) != want {
+				t.Errorf("last frame is %d, want %d", r>>8, want)
+			}
+		})
+	}
+}
+
+func TestReplayLimit(t *testing.T) {
+	r := New()
+	frame := image.NewRGBA(image.Rect(0, 0, 256, 192))
+	for i := 0; i < REPLAY_FRAMES; i++ {
+		// a new picture on every frame
+		next := image.NewRGBA(frame.Rect)
+		next.Pix[0] = byte(i)
+		next.Pix[1] = byte(i >> 8)
+		r.AddFrame(next)
+	}
+
+	size := 0
+	for _, frame := range r.Replay() {
+		size += len(frame.Image.Pix)
+	}
+	if size > REPLAY_MAX_BYTES {
+		t.Errorf("replay keeps %d bytes, more than %d", size, REPLAY_MAX_BYTES)
+	}
+
+	r = New()
+	small := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	for i := 0; i < REPLAY_FRAMES+60; i++ {
+		next := image.NewRGBA(small.Rect)
+		next.Set(0, 0, color.RGBA{R: byte(i), G: byte(i >> 8), A: 255})
+		r.AddFrame(next)
+	}
+	if frames := r.Replay(); len(frames) != REPLAY_FRAMES {
+		t.Errorf("replay keeps %d small frames, want %d", len(frames), REPLAY_FRAMES)
+	}
+}
+
+func TestAddFrameCopies(t *testing.T) {
+	r := New()
+	frame := solidFrame(10)
+	r.AddFrame(frame)
+	r.AddFrame(frame)
+
+	// the caller draws over its frame
+	for i := range frame.Pix {
+		frame.Pix[i] = 20
+	}
+	r.AddFrame(frame)
+
+	replay := r.Replay()
+	if len(replay) != 2 || replay[0].Duration != 2 || replay[1].Duration != 1 {
+		t.Fatalf("replay has %d frames, want frames of 2 and 1 ticks", len(replay))
+	}
+	if replay[0].Image.Pix[0] != 10 || replay[1].Image.Pix[0] != 20 {
+		t.Errorf("replay shows %d and %d, want 10 and 20", replay[0].Image.Pix[0], replay[1].Image.Pix[0])
+	}
+	if replay[1].Image == frame || r.Last() != replay[1].Image {
+		t.Error("the recorder keeps the caller's frame instead of a copy")
+	}
+}
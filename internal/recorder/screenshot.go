@@ -0,0 +1,35 @@
+package recorder
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+// SaveScreenshot writes frame to a PNG file, scaled up by scale.
+func SaveScreenshot(path string, frame *image.RGBA, scale int) error {
+	if scale < MIN_SCALE || scale > MAX_SCALE {
+		return fmt.Errorf("Scale must be between %d and %d, not %d", MIN_SCALE, MAX_SCALE, scale)
+	}
+
+	return writePNG(path, scaleImage(frame, scale))
+}
+
+// TextArt draws a display as text, one character per pixel, for pasting
+// where images can't go.
+func TextArt(video [][]byte) string {
+	var sb strings.Builder
+
+	for _, row := range video {
+		for _, pixel := range row {
+			if pixel == 0 {
+				sb.WriteByte('.')
+			} else {
+				sb.WriteByte('#')
+			}
+		}
+		sb.WriteByte('\n')
+	}
+
+	return sb.String()
+}
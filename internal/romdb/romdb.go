@@ -0,0 +1,96 @@
+package romdb
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/chip8"
+)
+
+// FILE_NAME is the file of the CHIP-8 community database (chip-8-database)
+// that lists the programs with the SHA-1 hashes of their ROMs.
+const FILE_NAME = "programs.json"
+
+// PLATFORMS are the platforms of the database that the emulator has a
+// platform for. The others run as plain CHIP-8 with their quirks.
+var PLATFORMS = map[string]chip8.Platform{
+	"chip8x":    chip8.PlatformChip8X,
+	"megachip8": chip8.PlatformMegaChip,
+}
+
+type program struct {
+	Title string         `json:"title"`
+	ROMs  map[string]rom `json:"roms"`
+}
+
+type rom struct {
+	// Platforms are the platforms the ROM runs on, the best first
+	Platforms []string `json:"platforms"`
+}
+
+// Database finds the titles and platforms of ROMs by their SHA-1 hashes,
+// the keys of their settings. A nil Database knows no ROMs.
+type Database struct {
+	titles    map[string]string
+	platforms map[string]chip8.Platform
+}
+
+func Load(path string) (*Database, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var programs []program
+	if err := json.Unmarshal(data, &programs); err != nil {
+		return nil, fmt.Errorf("Invalid ROM database %s: %w", path, err)
+	}
+
+	db := &Database{titles: map[string]string{}, platforms: map[string]chip8.Platform{}}
+	for _, program := range programs {
+		for key, rom := range program.ROMs {
+			db.titles[key] = program.Title
+			for _, name := range rom.Platforms {
+				if platform, ok := PLATFORMS[name]; ok {
+					db.platforms[key] = platform
+					break
+				}
+			}
+		}
+	}
+
+	return db, nil
+}
+
+// Title returns the title of the ROM with the given key, or "" if it is
+// unknown.
+func (db *Database) Title(key string) string {
+	if db == nil {
+		return ""
+	}
+
+	return db.titles[key]
+}
+
+// PlatformOf returns the platform of a known program, or PlatformAuto.
+func (db *Database) PlatformOf(program []byte) chip8.Platform {
+	if db == nil {
+		return chip8.PlatformAuto
+	}
+
+	// the same key as settings.ROMKey
+	sum := sha1.Sum(program)
+	return db.platforms[hex.EncodeToString(sum[:])]
+}
+
+// Len returns how many ROMs the database knows.
+func (db *Database) Len() int {
+	if db == nil {
+		return 0
+	}
+
+	return len(db.titles)
+}
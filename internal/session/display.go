@@ -0,0 +1,116 @@
+package session
+
+import (
+	"fmt"
+	"image"
+	"sync/atomic"
+
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/emulator"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/raster"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/recorder"
+)
+
+const (
+	DISPLAY_SCALE     = 10
+	MIN_DISPLAY_SCALE = 5
+	MAX_DISPLAY_SCALE = 20
+)
+
+// the display area fits a 64x32 video at DISPLAY_SCALE
+var (
+	DISPLAY_WIDTH  = 64 * DISPLAY_SCALE
+	DISPLAY_HEIGHT = 32 * DISPLAY_SCALE
+)
+
+// SetDisplayScale sizes the display area for a 64x32 video at scale. It
+// has to be called before any session is created.
+func SetDisplayScale(scale int) error {
+	if scale < MIN_DISPLAY_SCALE || scale > MAX_DISPLAY_SCALE {
+		return fmt.Errorf("Display scale must be between %d and %d, not %d", MIN_DISPLAY_SCALE, MAX_DISPLAY_SCALE, scale)
+	}
+
+	DISPLAY_WIDTH, DISPLAY_HEIGHT = 64*scale, 32*scale
+	return nil
+}
+
+// DisplaySize fits a video of the given resolution into the display area
+// while keeping its aspect ratio.
+func DisplaySize(videoWidth, videoHeight int) (int, int) {
+	if videoWidth*DISPLAY_HEIGHT > videoHeight*DISPLAY_WIDTH {
+		return DISPLAY_WIDTH, DISPLAY_WIDTH * videoHeight / videoWidth
+	}
+
+	return DISPLAY_HEIGHT * videoWidth / videoHeight, DISPLAY_HEIGHT
+}
+
+// DisplayBounds returns the size of the image shown. It is safe to call
+// from any goroutine.
+func (s *Session) DisplayBounds() image.Rectangle {
+	s.displayMu.Lock()
+	defer s.displayMu.Unlock()
+
+	if s.display == nil {
+		return image.Rectangle{}
+	}
+	return s.display.Rect
+}
+
+// DisplayDrawn counts the frames drawn on the display, so that the GUI
+// only uploads it when it has changed.
+func (s *Session) DisplayDrawn() uint64 {
+	return atomic.LoadUint64(&s.displayDrawn)
+}
+
+// WithDisplay calls f with the image shown. HandleFrame draws into the
+// same image, so f must not keep it.
+func (s *Session) WithDisplay(f func(display *image.RGBA)) {
+	s.displayMu.Lock()
+	defer s.displayMu.Unlock()
+
+	f(s.display)
+}
+
+// SetDisplay replaces the image shown, which the session owns afterwards.
+func (s *Session) SetDisplay(display *image.RGBA) {
+	s.displayMu.Lock()
+	s.display = display
+	s.displayMu.Unlock()
+
+	atomic.AddUint64(&s.displayDrawn, 1)
+}
+
+// HandleFrame is the emulator's OnFrame: it records every frame, filters
+// it and scales it onto the display. It runs on the emulator goroutine.
+func (s *Session) HandleFrame(snapshot *emulator.Snapshot) {
+	s.Recorder.AddFrame(snapshot.Frame)
+
+	shown := s.Filter.Apply(snapshot.Frame, snapshot.Background)
+	if s.lastShown != nil && recorder.SameImage(shown, s.lastShown) {
+		// nothing changed since the last frame
+		return
+	}
+	// the filter reuses its buffer, so keep a copy to compare with
+	s.lastShown = copyImage(s.lastShown, shown)
+
+	width, height := DisplaySize(shown.Rect.Dx(), shown.Rect.Dy())
+	s.displayMu.Lock()
+	// the display is only allocated again when the resolution changes
+	if s.display == nil || s.display.Rect.Dx() != width || s.display.Rect.Dy() != height {
+		s.display = image.NewRGBA(image.Rect(0, 0, width, height))
+	}
+	raster.Scale(s.display, shown)
+	s.displayMu.Unlock()
+
+	atomic.AddUint64(&s.displayDrawn, 1)
+}
+
+// copyImage copies src into dst, which is allocated again only when the
+// sizes differ.
+func copyImage(dst, src *image.RGBA) *image.RGBA {
+	if dst == nil || dst.Rect != src.Rect {
+		dst = image.NewRGBA(src.Rect)
+	}
+	copy(dst.Pix, src.Pix)
+
+	return dst
+}
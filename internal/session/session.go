@@ -0,0 +1,441 @@
+package session
+
+import (
+	"fmt"
+	"image"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/audio"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/chip8"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/emulator"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/filter"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/logger"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/palette"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/recorder"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/settings"
+)
+
+// Session is one running emulator with everything around it: its sound,
+// display, settings, recordings and log. main builds it and passes it to
+// the GUI.
+type Session struct {
+	// Emulator runs the VM. Read its state through Emulator.Snapshot.
+	Emulator *emulator.Emulator
+	Audio    *audio.Audio
+	Log      *logger.Logger
+	// Clipboard receives text copied from the session
+	Clipboard func(text string)
+
+	// romKey identifies the loaded ROM in the settings
+	romKey string
+
+	LoadConfig chip8.Config
+
+	// displayMu guards display, which HandleFrame draws into in place
+	displayMu sync.Mutex
+	display   *image.RGBA
+	// displayDrawn counts the frames drawn into display
+	displayDrawn uint64
+	// Filter turns the frames of the VM into what the display shows
+	Filter *filter.Filter
+	// lastShown is the last filtered frame, owned by HandleFrame
+	lastShown *image.RGBA
+	// activePalette holds the palette.Palette the display is drawn in
+	activePalette atomic.Value
+
+	// Settings are shared by a session and its forks, so that every
+	// instance saves the same settings
+	Settings *settings.Settings
+
+	Recorder     *recorder.Recorder
+	RecordFormat recorder.Format
+	RecordScale  int
+	// RecordPath is where the next recording is saved. If it is empty,
+	// recordings are saved in the working directory under a name with the
+	// time.
+	RecordPath string
+
+	// ScreenshotScale is 1 for the native resolution, up to 10 for the
+	// size of the display
+	ScreenshotScale int
+}
+
+// New creates a session around emu, which plays its sound on sound.
+// Without a window (headless mode) both can be nil.
+func New(emu *emulator.Emulator, sound *audio.Audio, log *logger.Logger) *Session {
+	defaults := settings.Default()
+	s := &Session{
+		Emulator:        emu,
+		Audio:           sound,
+		Log:             log,
+		Settings:        &defaults,
+		Filter:          filter.New(filter.DefaultSettings()),
+		Recorder:        recorder.New(),
+		RecordScale:     4,
+		ScreenshotScale: 10,
+	}
+	s.activePalette.Store(palette.Default())
+
+	if emu != nil {
+		s.romKey = settings.ROMKey(emu.Snapshot().Program)
+	}
+
+	return s
+}
+
+// Fork creates a session for another emulator with the options and log of
+// s. It shares the settings of s.
+func (s *Session) Fork(emu *emulator.Emulator, sound *audio.Audio) *Session {
+	fork := New(emu, sound, s.Log)
+	fork.Clipboard = s.Clipboard
+	fork.LoadConfig = s.LoadConfig
+	fork.Settings = s.Settings
+	fork.Filter.SetSettings(s.Settings.Video)
+	fork.RecordFormat = s.RecordFormat
+	fork.RecordScale = s.RecordScale
+	fork.ScreenshotScale = s.ScreenshotScale
+	s.WithDisplay(func(display *image.RGBA) {
+		fork.SetDisplay(copyImage(nil, display))
+	})
+
+	return fork
+}
+
+// LoadVM makes vm the running VM. Without an emulator (headless mode) only
+// the settings follow the new ROM.
+func (s *Session) LoadVM(vm *chip8.VirtualMachine) {
+	s.romKey = settings.ROMKey(vm.Program())
+	s.UpdatePalette()
+	s.Log.Debugf("settings", "ROM key %s, clock %d, quirks %s", s.romKey, s.Settings.ClockFor(s.romKey), vm.Quirks)
+
+	if s.Audio != nil {
+		s.Audio.SetSettings(s.Settings.AudioFor(s.romKey))
+	}
+	if s.Emulator != nil {
+		s.Emulator.Load(vm)
+		s.Emulator.SetClockRate(s.Settings.ClockFor(s.romKey))
+	}
+}
+
+func (s *Session) ResetVM() {
+	s.Emulator.Reset()
+	// commands run in order, so this is logged once the reset is done
+	s.Emulator.Do(func(vm *chip8.VirtualMachine) {
+		s.Log.Infof("vm", "Reset VM completed.")
+	})
+}
+
+// HandleError is the emulator's OnError. It runs on the emulator
+// goroutine.
+func (s *Session) HandleError(err error) {
+	s.Log.Errorf("vm", "VM stopped by an error. (%s)", err)
+}
+
+func (s *Session) StopVM() {
+	s.Emulator.Pause()
+	s.Log.Infof("vm", "VM stopped.")
+}
+
+func (s *Session) StartVM() {
+	s.Emulator.Resume()
+	s.Log.Infof("vm", "VM started.")
+}
+
+func (s *Session) StartRecording() {
+	s.Recorder.Start()
+	s.Log.Infof("record", "Recording started.")
+}
+
+// StopRecording stops the recording and saves it.
+func (s *Session) StopRecording() {
+	path := s.RecordPath
+	if path == "" {
+		path = recordingName("chip8", s.RecordFormat)
+	}
+	s.RecordPath = ""
+
+	s.saveFrames(path, s.Recorder.Stop())
+}
+
+// SaveReplay saves the last 10 seconds of the display.
+func (s *Session) SaveReplay() {
+	s.saveFrames(recordingName("chip8-replay", s.RecordFormat), s.Recorder.Replay())
+}
+
+func (s *Session) saveFrames(path string, frames []recorder.Frame) {
+	if err := recorder.Save(path, frames, s.RecordFormat, s.RecordScale); err != nil {
+		s.Log.Errorf("record", "Saving recording failed. (%s)", err)
+		return
+	}
+
+	s.Log.Infof("record", "Recording saved. (PATH: %s)", path)
+}
+
+func recordingName(prefix string, format recorder.Format) string {
+	return fmt.Sprintf("%s-%s%s", prefix, time.Now().Format("20060102-150405"), format.Extension())
+}
+
+// Screenshot saves the display as a PNG file in the working directory.
+func (s *Session) Screenshot() {
+	// the snapshot's frame is drawn over, the recorder keeps a copy
+	frame := s.Recorder.Last()
+	if frame == nil {
+		s.Log.Errorf("record", "Saving screenshot failed. (Nothing was shown yet)")
+		return
+	}
+	path := recordingName("chip8-screenshot", recorder.FormatPNG)
+
+	if err := recorder.SaveScreenshot(path, frame, s.ScreenshotScale); err != nil {
+		s.Log.Errorf("record", "Saving screenshot failed. (%s)", err)
+		return
+	}
+
+	s.Log.Infof("record", "Screenshot saved. (PATH: %s)", path)
+}
+
+// CopyTextArt copies the display to the clipboard as text.
+func (s *Session) CopyTextArt() {
+	s.Clipboard(recorder.TextArt(s.Emulator.Snapshot().Video))
+	s.Log.Infof("record", "Display copied to the clipboard as text.")
+}
+
+// CopyLog copies the log entries that match filter to the clipboard.
+func (s *Session) CopyLog(filter logger.Filter) {
+	s.Clipboard(s.Log.Text(filter))
+}
+
+// ExportLog saves the log entries that match filter as a text file in the
+// working directory.
+func (s *Session) ExportLog(filter logger.Filter) {
+	path := fmt.Sprintf("chip8-log-%s.txt", time.Now().Format("20060102-150405"))
+	if err := s.Log.Export(path, filter); err != nil {
+		s.Log.Errorf("main", "Exporting log failed. (%s)", err)
+		return
+	}
+
+	s.Log.Infof("main", "Log exported. (PATH: %s)", path)
+}
+
+// SetAudioSettings applies new buzzer settings. They are not stored until
+// SaveSettings is called.
+func (s *Session) SetAudioSettings(settings audio.Settings) {
+	s.Settings.Audio = settings
+	s.Audio.SetSettings(s.Settings.AudioFor(s.ROMKey()))
+}
+
+// SetVideoSettings applies new display filter settings. They are not
+// stored until SaveSettings is called.
+func (s *Session) SetVideoSettings(settings filter.Settings) {
+	s.Settings.Video = settings
+	s.Filter.SetSettings(settings)
+}
+
+// Palette returns the colours the display is drawn in. It is safe to call
+// from any goroutine.
+func (s *Session) Palette() palette.Palette {
+	return s.activePalette.Load().(palette.Palette)
+}
+
+// ROMKey identifies the loaded ROM in the settings.
+func (s *Session) ROMKey() string {
+	return s.romKey
+}
+
+// UpdatePalette picks the palette of the loaded ROM from the settings. It
+// has to be called whenever another ROM is loaded.
+func (s *Session) UpdatePalette() {
+	p := s.Settings.PaletteFor(s.ROMKey())
+	s.activePalette.Store(p)
+
+	if s.Emulator != nil {
+		s.Emulator.SetPalette(p)
+	}
+}
+
+// SetPalette changes the palette, only for the loaded ROM if perROM is set.
+// It is not stored until SaveSettings is called.
+func (s *Session) SetPalette(p palette.Palette, perROM bool) {
+	if perROM {
+		if s.Settings.ROMs == nil {
+			s.Settings.ROMs = map[string]settings.ROMSettings{}
+		}
+		key := s.ROMKey()
+		rom := s.Settings.ROMs[key]
+		rom.Palette = &p
+		s.Settings.ROMs[key] = rom
+	} else {
+		s.Settings.Palette = p
+	}
+
+	s.UpdatePalette()
+}
+
+// HasROMPalette tells whether the loaded ROM has a palette of its own.
+func (s *Session) HasROMPalette() bool {
+	return s.Settings.ROMs[s.ROMKey()].Palette != nil
+}
+
+// ClearROMPalette makes the loaded ROM use the global palette again.
+func (s *Session) ClearROMPalette() {
+	key := s.ROMKey()
+	if rom, ok := s.Settings.ROMs[key]; ok {
+		rom.Palette = nil
+		if rom == (settings.ROMSettings{}) {
+			delete(s.Settings.ROMs, key)
+		} else {
+			s.Settings.ROMs[key] = rom
+		}
+	}
+
+	s.UpdatePalette()
+}
+
+// SetClock changes the clock rate, only for the loaded ROM if perROM is
+// set. It replaces the clock rate given on the command line. It is not
+// stored until SaveSettings is called.
+func (s *Session) SetClock(rate int, perROM bool) {
+	s.Settings.Flags.Clock = nil
+	if perROM {
+		if s.Settings.ROMs == nil {
+			s.Settings.ROMs = map[string]settings.ROMSettings{}
+		}
+		key := s.ROMKey()
+		rom := s.Settings.ROMs[key]
+		rom.Clock = &rate
+		s.Settings.ROMs[key] = rom
+	} else {
+		s.Settings.Clock = rate
+	}
+
+	s.Emulator.SetClockRate(s.Settings.ClockFor(s.ROMKey()))
+}
+
+// HasROMClock tells whether the loaded ROM has a clock rate of its own.
+func (s *Session) HasROMClock() bool {
+	return s.Settings.ROMs[s.ROMKey()].Clock != nil
+}
+
+// ClearROMClock makes the loaded ROM use the global clock rate again.
+func (s *Session) ClearROMClock() {
+	key := s.ROMKey()
+	if rom, ok := s.Settings.ROMs[key]; ok {
+		rom.Clock = nil
+		if rom == (settings.ROMSettings{}) {
+			delete(s.Settings.ROMs, key)
+		} else {
+			s.Settings.ROMs[key] = rom
+		}
+	}
+
+	s.Emulator.SetClockRate(s.Settings.ClockFor(key))
+}
+
+// Keys returns the keyboard keys of both keypads for the loaded ROM.
+func (s *Session) Keys() (settings.Keys, settings.Keys) {
+	return s.Settings.KeysFor(s.ROMKey())
+}
+
+// SetKeys changes the keyboard keys of keypad 1 or 2, only for the loaded
+// ROM if perROM is set. The keys given to the keypad are taken from the
+// other one. It is not stored until SaveSettings is called.
+func (s *Session) SetKeys(keypad int, keys settings.Keys, perROM bool) {
+	keys1, keys2 := s.Keys()
+	own, other := &keys1, &keys2
+	if keypad == 2 {
+		own, other = other, own
+	}
+	*own = keys.Copy()
+	*other = other.Copy()
+	for _, bound := range keys {
+		for _, key := range bound {
+			other.Unbind(key)
+		}
+	}
+
+	if perROM {
+		if s.Settings.ROMs == nil {
+			s.Settings.ROMs = map[string]settings.ROMSettings{}
+		}
+		key := s.ROMKey()
+		rom := s.Settings.ROMs[key]
+		rom.Keys, rom.Keys2 = &keys1, &keys2
+		s.Settings.ROMs[key] = rom
+	} else {
+		s.Settings.Keys, s.Settings.Keys2 = keys1, keys2
+	}
+}
+
+// HasROMKeys tells whether the loaded ROM has keyboard keys of its own.
+func (s *Session) HasROMKeys() bool {
+	rom := s.Settings.ROMs[s.ROMKey()]
+	return rom.Keys != nil || rom.Keys2 != nil
+}
+
+// ClearROMKeys makes the loaded ROM use the global keyboard keys again.
+func (s *Session) ClearROMKeys() {
+	key := s.ROMKey()
+	if rom, ok := s.Settings.ROMs[key]; ok {
+		rom.Keys, rom.Keys2 = nil, nil
+		if rom == (settings.ROMSettings{}) {
+			delete(s.Settings.ROMs, key)
+		} else {
+			s.Settings.ROMs[key] = rom
+		}
+	}
+}
+
+// SaveState saves the state of the VM to path.
+func (s *Session) SaveState(path string) {
+	result := make(chan error)
+	s.Emulator.Do(func(vm *chip8.VirtualMachine) {
+		result <- vm.SaveStateFile(path)
+	})
+
+	if err := <-result; err != nil {
+		s.Log.Errorf("state", "Saving state failed. (%s)", err)
+		return
+	}
+	s.Log.Infof("state", "State saved. (PATH: %s)", path)
+}
+
+// LoadState continues from a state saved by SaveState.
+func (s *Session) LoadState(path string) {
+	vm, err := chip8.LoadStateFile(path)
+	if err != nil {
+		s.Log.Errorf("state", "Loading state failed. (%s)", err)
+		return
+	}
+
+	s.LoadVM(vm)
+	s.Log.Infof("state", "State loaded. (PATH: %s)", path)
+}
+
+// QuickSave saves the state of the VM to the slot of the loaded ROM.
+func (s *Session) QuickSave() {
+	path, err := settings.StatePath(s.ROMKey())
+	if err != nil {
+		s.Log.Errorf("state", "Saving state failed. (%s)", err)
+		return
+	}
+
+	s.SaveState(path)
+}
+
+// QuickLoad loads the state in the slot of the loaded ROM.
+func (s *Session) QuickLoad() {
+	path, err := settings.StatePath(s.ROMKey())
+	if err != nil {
+		s.Log.Errorf("state", "Loading state failed. (%s)", err)
+		return
+	}
+
+	s.LoadState(path)
+}
+
+func (s *Session) SaveSettings() {
+	if err := s.Settings.Save(); err != nil {
+		s.Log.Errorf("settings", "Failed to save settings. (%s)", err)
+	}
+}
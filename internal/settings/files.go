@@ -0,0 +1,57 @@
+package settings
+
+import (
+	"path/filepath"
+
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/romdb"
+)
+
+// RECENT_FILES is how many ROMs are kept in Recent.
+const RECENT_FILES = 10
+
+// AddRecent moves a loaded ROM to the top of Recent.
+func (s *Settings) AddRecent(path string) {
+	recent := []string{path}
+	for _, other := range s.Recent {
+		if other != path && len(recent) < RECENT_FILES {
+			recent = append(recent, other)
+		}
+	}
+
+	s.Recent = recent
+}
+
+func (s Settings) IsFavorite(path string) bool {
+	for _, favorite := range s.Favorites {
+		if favorite == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ToggleFavorite adds a ROM to the favourites, or removes it if it is one.
+func (s *Settings) ToggleFavorite(path string) {
+	favorites := []string{}
+	for _, favorite := range s.Favorites {
+		if favorite != path {
+			favorites = append(favorites, favorite)
+		}
+	}
+	if len(favorites) == len(s.Favorites) {
+		favorites = append(favorites, path)
+	}
+
+	s.Favorites = favorites
+}
+
+// DatabasePath returns where the ROM database is read from.
+func DatabasePath() (string, error) {
+	path, err := Path()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(filepath.Dir(path), romdb.FILE_NAME), nil
+}
@@ -0,0 +1,309 @@
+package settings
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// KEYBOARD_KEYS are the printable keys that can be bound, named by the
+// character on them. Their GLFW key codes are the same characters.
+const KEYBOARD_KEYS = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ',-./;=[\\]`"
+
+// SCANCODE_PREFIX names a key bound by its physical position, as in
+// "scancode:38", instead of by the character the layout gives it.
+const SCANCODE_PREFIX = "scancode:"
+
+// NAMED_KEYS are the GLFW key codes of the keys without a character.
+var NAMED_KEYS = map[string]int{
+	"Space": 32, "Enter": 257, "Tab": 258, "Backspace": 259,
+	"Right": 262, "Left": 263, "Down": 264, "Up": 265,
+	"KP0": 320, "KP1": 321, "KP2": 322, "KP3": 323, "KP4": 324,
+	"KP5": 325, "KP6": 326, "KP7": 327, "KP8": 328, "KP9": 329,
+	"KPDecimal": 330, "KPDivide": 331, "KPMultiply": 332,
+	"KPSubtract": 333, "KPAdd": 334, "KPEnter": 335,
+}
+
+// Key is a keyboard key: a GLFW key code, or a platform scancode when
+// Scancode is set.
+type Key struct {
+	Code     int
+	Scancode bool
+}
+
+// ParseKey reads a key named as printed by Key.String. Characters are
+// accepted in either case.
+func ParseKey(name string) (Key, error) {
+	if strings.HasPrefix(name, SCANCODE_PREFIX) {
+		var code int
+		if _, err := fmt.Sscanf(name[len(SCANCODE_PREFIX):], "%d", &code); err != nil || code <= 0 {
+			return Key{}, fmt.Errorf("Invalid scancode %q", name)
+		}
+		return Key{Code: code, Scancode: true}, nil
+	}
+	if code, ok := NAMED_KEYS[name]; ok {
+		return Key{Code: code}, nil
+	}
+
+	r, size := utf8.DecodeRuneInString(strings.ToUpper(name))
+	if size == 0 || size != len(name) || !strings.ContainsRune(KEYBOARD_KEYS, r) {
+		return Key{}, fmt.Errorf("Invalid keyboard key %q, it must be one of %s, a key name like Up or KP8, or %sN", name, KEYBOARD_KEYS, SCANCODE_PREFIX)
+	}
+
+	return Key{Code: int(r)}, nil
+}
+
+// Validate checks that the key can be stored in the settings.
+func (k Key) Validate() error {
+	if k.Scancode {
+		if k.Code <= 0 {
+			return fmt.Errorf("Invalid scancode %d", k.Code)
+		}
+		return nil
+	}
+
+	_, err := ParseKey(k.String())
+	return err
+}
+
+func (k Key) String() string {
+	if k.Scancode {
+		return fmt.Sprintf("%s%d", SCANCODE_PREFIX, k.Code)
+	}
+	for name, code := range NAMED_KEYS {
+		if code == k.Code {
+			return name
+		}
+	}
+	if k.Code < utf8.RuneSelf && strings.ContainsRune(KEYBOARD_KEYS, rune(k.Code)) {
+		return string(rune(k.Code))
+	}
+
+	return fmt.Sprintf("key %d", k.Code)
+}
+
+// Keys maps the hex keypad to the keyboard: Keys[k] are the keyboard keys
+// for the keypad key k, any of which presses it. In the settings file they
+// are an object like {"0": "X", "5": ["W", "Up"]}, where missing keypad
+// keys keep their default.
+type Keys [16][]Key
+
+// KeysPreset is a named layout of the first keypad.
+type KeysPreset struct {
+	Name string
+	Keys Keys
+}
+
+// keysOf binds the keypad keys to the keys named in rows, laid out like
+// the keypad.
+func keysOf(rows ...[4]string) Keys {
+	var keys Keys
+	for row, names := range rows {
+		for column, name := range names {
+			pad := KEYPAD_ORDER[row*4+column]
+			key, err := ParseKey(name)
+			if err != nil {
+				panic(err)
+			}
+			keys[pad] = append(keys[pad], key)
+		}
+	}
+
+	return keys
+}
+
+// withArrows adds the arrow keys to 2, 4, 6 and 8 and space to 5.
+func withArrows(keys Keys) Keys {
+	for pad, name := range map[uint]string{0x2: "Up", 0x4: "Left", 0x6: "Right", 0x8: "Down", 0x5: "Space"} {
+		keys[pad] = append(keys[pad], Key{Code: NAMED_KEYS[name]})
+	}
+
+	return keys
+}
+
+// KEYPAD_ORDER is the hex keypad as laid out on the COSMAC VIP, row by row.
+var KEYPAD_ORDER = [16]uint{
+	0x1, 0x2, 0x3, 0xC,
+	0x4, 0x5, 0x6, 0xD,
+	0x7, 0x8, 0x9, 0xE,
+	0xA, 0x0, 0xB, 0xF,
+}
+
+// KeysPresets are the layouts offered for the first keypad. The letter
+// layouts keep the keypad on the same four by four block of keys.
+var KeysPresets = []KeysPreset{
+	{"QWERTY", keysOf(
+		[4]string{"1", "2", "3", "4"},
+		[4]string{"Q", "W", "E", "R"},
+		[4]string{"A", "S", "D", "F"},
+		[4]string{"Z", "X", "C", "V"},
+	)},
+	{"AZERTY", keysOf(
+		[4]string{"1", "2", "3", "4"},
+		[4]string{"A", "Z", "E", "R"},
+		[4]string{"Q", "S", "D", "F"},
+		[4]string{"W", "X", "C", "V"},
+	)},
+	{"Dvorak", keysOf(
+		[4]string{"1", "2", "3", "4"},
+		[4]string{"'", ",", ".", "P"},
+		[4]string{"A", "O", "E", "U"},
+		[4]string{";", "Q", "J", "K"},
+	)},
+	// games that move with 2, 4, 6 and 8 also get the arrow keys
+	{"QWERTY + arrows", withArrows(keysOf(
+		[4]string{"1", "2", "3", "4"},
+		[4]string{"Q", "W", "E", "R"},
+		[4]string{"A", "S", "D", "F"},
+		[4]string{"Z", "X", "C", "V"},
+	))},
+	{"Numpad", keysOf(
+		[4]string{"KP1", "KP2", "KP3", "KPDivide"},
+		[4]string{"KP4", "KP5", "KP6", "KPMultiply"},
+		[4]string{"KP7", "KP8", "KP9", "KPSubtract"},
+		[4]string{"KPDecimal", "KP0", "KPEnter", "KPAdd"},
+	)},
+}
+
+func DefaultKeys() Keys {
+	return KeysPresets[0].Keys.Copy()
+}
+
+// DefaultKeys2 maps the second keypad of CHIP-8X to the right-hand side of
+// the keyboard, laid out like DefaultKeys.
+func DefaultKeys2() Keys {
+	return keysOf(
+		[4]string{"7", "8", "9", "0"},
+		[4]string{"U", "I", "O", "P"},
+		[4]string{"J", "K", "L", ";"},
+		[4]string{"M", ",", ".", "/"},
+	)
+}
+
+// Preset returns the name of the preset with these keys, or "Custom".
+func (k Keys) Preset() string {
+	for _, preset := range KeysPresets {
+		if reflect.DeepEqual(preset.Keys, k) {
+			return preset.Name
+		}
+	}
+
+	return "Custom"
+}
+
+// Copy returns keys that share no slices with k.
+func (k Keys) Copy() Keys {
+	var keys Keys
+	for pad := range k {
+		keys[pad] = append([]Key(nil), k[pad]...)
+	}
+
+	return keys
+}
+
+// Bound returns the keypad key the keyboard key is bound to.
+func (k Keys) Bound(key Key) (uint, bool) {
+	for pad, bound := range k {
+		for _, other := range bound {
+			if other == key {
+				return uint(pad), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// Unbind removes the keyboard key from every keypad key.
+func (k *Keys) Unbind(key Key) {
+	for pad, bound := range k {
+		keys := bound[:0:0]
+		for _, other := range bound {
+			if other != key {
+				keys = append(keys, other)
+			}
+		}
+		k[pad] = keys
+	}
+}
+
+// Names lists the keyboard keys of a keypad key, separated by commas.
+func (k Keys) Names(pad uint) string {
+	names := make([]string, len(k[pad]))
+	for i, key := range k[pad] {
+		names[i] = key.String()
+	}
+
+	return strings.Join(names, ", ")
+}
+
+func (k Keys) MarshalJSON() ([]byte, error) {
+	keys := map[string]interface{}{}
+	for pad, bound := range k {
+		names := make([]string, len(bound))
+		for i, key := range bound {
+			names[i] = key.String()
+		}
+		if len(names) == 1 {
+			keys[fmt.Sprintf("%X", pad)] = names[0]
+		} else {
+			keys[fmt.Sprintf("%X", pad)] = names
+		}
+	}
+
+	return json.Marshal(keys)
+}
+
+func (k *Keys) UnmarshalJSON(data []byte) error {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+
+	for name, value := range keys {
+		var pad int
+		if _, err := fmt.Sscanf(name, "%X", &pad); err != nil || len(name) != 1 {
+			return fmt.Errorf("Invalid keypad key %q, it must be 0 to F", name)
+		}
+
+		// a single key may be given without a list
+		var names []string
+		var single string
+		if err := json.Unmarshal(value, &single); err == nil {
+			names = []string{single}
+		} else if err := json.Unmarshal(value, &names); err != nil {
+			return fmt.Errorf("Invalid keyboard keys for keypad key %X, they must be a key or a list of keys", pad)
+		}
+
+		k[pad] = nil
+		for _, name := range names {
+			key, err := ParseKey(name)
+			if err != nil {
+				return fmt.Errorf("%w for keypad key %X", err, pad)
+			}
+			k[pad] = append(k[pad], key)
+		}
+	}
+
+	return nil
+}
+
+// validateKeys checks that no keyboard key is bound twice in any of the
+// keypads.
+func validateKeys(keypads ...Keys) error {
+	bound := map[Key]int{}
+	for _, keys := range keypads {
+		for pad, keys := range keys {
+			for _, key := range keys {
+				if other, ok := bound[key]; ok {
+					return fmt.Errorf("Keyboard key %s is bound to both keypad keys %X and %X", key, other, pad)
+				}
+				bound[key] = pad
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,358 @@
+package settings
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/audio"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/emulator"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/filter"
+	"github.com/kaishuu0123/chip-8-dear-imgui/internal/palette"
+)
+
+const (
+	APP_NAME   = "chip-8-dear-imgui"
+	FILE_NAME  = "settings.json"
+	STATES_DIR = "states"
+	// BACKUP_SUFFIX is added to the name of a settings file that couldn't
+	// be read before it is saved over
+	BACKUP_SUFFIX = ".bak"
+)
+
+const (
+	WINDOW_WIDTH      = 800
+	WINDOW_HEIGHT     = 600
+	MAX_WINDOW_WIDTH  = 16384
+	MAX_WINDOW_HEIGHT = 16384
+)
+
+// Settings are the user's preferences that are kept between sessions. They
+// are layered: the defaults, then the settings file, then the overrides of
+// the loaded ROM, then the command line.
+type Settings struct {
+	Window  Window          `json:"window"`
+	Keys    Keys            `json:"keys"`
+	Keys2   Keys            `json:"keys2"`
+	Clock   int             `json:"clock"`
+	Audio   audio.Settings  `json:"audio"`
+	Video   filter.Settings `json:"video"`
+	Palette palette.Palette `json:"palette"`
+	LastROM string          `json:"last_rom,omitempty"`
+	// Recent are the ROMs loaded last, the latest first
+	Recent    []string `json:"recent,omitempty"`
+	Favorites []string `json:"favorites,omitempty"`
+	// BrowseDir is the directory the ROM browser showed last
+	BrowseDir string `json:"browse_dir,omitempty"`
+
+	// ROMs override settings for single ROMs, by ROMKey
+	ROMs map[string]ROMSettings `json:"roms,omitempty"`
+
+	// Flags override everything for this run and are never saved
+	Flags ROMSettings `json:"-"`
+
+	// unreadable is set when the settings file couldn't be read at all.
+	// It is kept as a backup before it is saved over.
+	unreadable bool
+}
+
+// Window is the size of the main window, without the columns of other
+// instances.
+type Window struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func (w Window) Validate() error {
+	if w.Width < WINDOW_WIDTH || w.Width > MAX_WINDOW_WIDTH {
+		return fmt.Errorf("Window width must be between %d and %d, not %d", WINDOW_WIDTH, MAX_WINDOW_WIDTH, w.Width)
+	}
+	if w.Height < WINDOW_HEIGHT || w.Height > MAX_WINDOW_HEIGHT {
+		return fmt.Errorf("Window height must be between %d and %d, not %d", WINDOW_HEIGHT, MAX_WINDOW_HEIGHT, w.Height)
+	}
+
+	return nil
+}
+
+// ROMSettings override the settings for one ROM. Unset fields keep the
+// global settings.
+type ROMSettings struct {
+	Palette *palette.Palette `json:"palette,omitempty"`
+	Clock   *int             `json:"clock,omitempty"`
+	Mute    *bool            `json:"mute,omitempty"`
+	Keys    *Keys            `json:"keys,omitempty"`
+	Keys2   *Keys            `json:"keys2,omitempty"`
+}
+
+func (r ROMSettings) Validate() error {
+	if r.Clock != nil {
+		return validateClock(*r.Clock)
+	}
+
+	return nil
+}
+
+func validateClock(clock int) error {
+	if clock < emulator.MIN_CLOCK_RATE || clock > emulator.MAX_CLOCK_RATE {
+		return fmt.Errorf("Clock must be between %d and %d instructions per second, not %d", emulator.MIN_CLOCK_RATE, emulator.MAX_CLOCK_RATE, clock)
+	}
+
+	return nil
+}
+
+// ROMKey identifies a ROM by its contents, so that its settings follow it
+// when it is renamed or moved.
+func ROMKey(program []byte) string {
+	sum := sha1.Sum(program)
+	return hex.EncodeToString(sum[:])
+}
+
+// PaletteFor returns the palette for the ROM with the given key.
+func (s Settings) PaletteFor(key string) palette.Palette {
+	if s.Flags.Palette != nil {
+		return *s.Flags.Palette
+	}
+	if rom, ok := s.ROMs[key]; ok && rom.Palette != nil {
+		return *rom.Palette
+	}
+
+	return s.Palette
+}
+
+// ClockFor returns the clock rate for the ROM with the given key.
+func (s Settings) ClockFor(key string) int {
+	if s.Flags.Clock != nil {
+		return *s.Flags.Clock
+	}
+	if rom, ok := s.ROMs[key]; ok && rom.Clock != nil {
+		return *rom.Clock
+	}
+
+	return s.Clock
+}
+
+// KeysFor returns the keyboard keys of both keypads for the ROM with the
+// given key.
+func (s Settings) KeysFor(key string) (Keys, Keys) {
+	keys, keys2 := s.Keys, s.Keys2
+	if rom, ok := s.ROMs[key]; ok {
+		if rom.Keys != nil {
+			keys = *rom.Keys
+		}
+		if rom.Keys2 != nil {
+			keys2 = *rom.Keys2
+		}
+	}
+
+	return keys, keys2
+}
+
+// AudioFor returns the buzzer settings for the ROM with the given key.
+func (s Settings) AudioFor(key string) audio.Settings {
+	settings := s.Audio
+	if rom, ok := s.ROMs[key]; ok && rom.Mute != nil {
+		settings.Mute = *rom.Mute
+	}
+	if s.Flags.Mute != nil {
+		settings.Mute = *s.Flags.Mute
+	}
+
+	return settings
+}
+
+func Default() Settings {
+	return Settings{
+		Window:  Window{Width: WINDOW_WIDTH, Height: WINDOW_HEIGHT},
+		Keys:    DefaultKeys(),
+		Keys2:   DefaultKeys2(),
+		Clock:   emulator.CLOCK_RATE,
+		Audio:   audio.DefaultSettings(),
+		Video:   filter.DefaultSettings(),
+		Palette: palette.Default(),
+	}
+}
+
+// Path returns where the settings are stored.
+func Path() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, APP_NAME, FILE_NAME), nil
+}
+
+// StatePath returns where the quick save state of the ROM with the given
+// key is stored. The directory is created if needed.
+func StatePath(key string) (string, error) {
+	path, err := Path()
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(filepath.Dir(path), STATES_DIR)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, key+".state"), nil
+}
+
+// Load reads the stored settings. Anything that isn't stored keeps its
+// default, and a missing file is not an error. Invalid settings are
+// reported and only they go back to their defaults.
+func Load() (Settings, error) {
+	s := Default()
+
+	path, err := Path()
+	if err != nil {
+		return s, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		s.unreadable = true
+		return s, fmt.Errorf("Invalid settings file %s, it is kept as %s%s: %w", path, path, BACKUP_SUFFIX, err)
+	}
+	problems := s.decode(fields)
+	problems = append(problems, s.validate()...)
+	if len(problems) > 0 {
+		return s, fmt.Errorf("Invalid settings in %s: %s", path, strings.Join(problems, "; "))
+	}
+
+	return s, nil
+}
+
+// decode reads the settings one by one, so that a value that can't be read
+// leaves only its own setting at the default.
+func (s *Settings) decode(fields map[string]json.RawMessage) []string {
+	var problems []string
+	targets := map[string]interface{}{
+		"window":     &s.Window,
+		"keys":       &s.Keys,
+		"keys2":      &s.Keys2,
+		"clock":      &s.Clock,
+		"audio":      &s.Audio,
+		"video":      &s.Video,
+		"palette":    &s.Palette,
+		"last_rom":   &s.LastROM,
+		"recent":     &s.Recent,
+		"favorites":  &s.Favorites,
+		"browse_dir": &s.BrowseDir,
+	}
+	for name, data := range fields {
+		if name == "roms" {
+			problems = append(problems, s.decodeROMs(data)...)
+			continue
+		}
+		target, ok := targets[name]
+		if !ok {
+			continue
+		}
+
+		// decode over a copy of the default, which is kept on errors
+		value := reflect.New(reflect.TypeOf(target).Elem())
+		value.Elem().Set(reflect.ValueOf(target).Elem())
+		if err := json.Unmarshal(data, value.Interface()); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, err))
+			continue
+		}
+		reflect.ValueOf(target).Elem().Set(value.Elem())
+	}
+
+	return problems
+}
+
+func (s *Settings) decodeROMs(data json.RawMessage) []string {
+	var roms map[string]json.RawMessage
+	if err := json.Unmarshal(data, &roms); err != nil {
+		return []string{fmt.Sprintf("roms: %s", err)}
+	}
+
+	var problems []string
+	s.ROMs = map[string]ROMSettings{}
+	for key, data := range roms {
+		var rom ROMSettings
+		if err := json.Unmarshal(data, &rom); err != nil {
+			problems = append(problems, fmt.Sprintf("roms.%s: %s", key, err))
+			continue
+		}
+		s.ROMs[key] = rom
+	}
+
+	return problems
+}
+
+// validate resets the invalid settings to their defaults and describes
+// what was wrong with them.
+func (s *Settings) validate() []string {
+	var problems []string
+	check := func(name string, err error, reset func()) {
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, err))
+			reset()
+		}
+	}
+	defaults := Default()
+
+	check("window", s.Window.Validate(), func() { s.Window = defaults.Window })
+	check("keys", validateKeys(s.Keys, s.Keys2), func() { s.Keys, s.Keys2 = defaults.Keys, defaults.Keys2 })
+	check("clock", validateClock(s.Clock), func() { s.Clock = defaults.Clock })
+	check("audio", s.Audio.Validate(), func() { s.Audio = defaults.Audio })
+	check("video", s.Video.Validate(), func() { s.Video = defaults.Video })
+	for key, rom := range s.ROMs {
+		key := key
+		if err := rom.Validate(); err != nil {
+			check("roms."+key, err, func() { delete(s.ROMs, key) })
+			continue
+		}
+		check("roms."+key+".keys", validateKeys(s.KeysFor(key)), func() {
+			rom.Keys, rom.Keys2 = nil, nil
+			s.ROMs[key] = rom
+		})
+	}
+
+	return problems
+}
+
+// Save stores the settings. A settings file that couldn't be read is
+// renamed with BACKUP_SUFFIX first, so that it isn't lost.
+func (s *Settings) Save() error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if s.unreadable {
+		if err := os.Rename(path, path+BACKUP_SUFFIX); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		s.unreadable = false
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}